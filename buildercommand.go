@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 
@@ -48,7 +49,7 @@ func (cmd *builderCommand) Name() string {
 	return custom.Name()
 }
 
-func (cmd *builderCommand) Run(name string, args []string) error {
+func (cmd *builderCommand) Run(ctx context.Context, name string, args []string) error {
 	parameters := flag.NewFlagSet(name, flag.ExitOnError)
 	cmd.mapper.SetupParameters(parameters)
 	if err := parameters.Parse(args); err != nil {
@@ -58,7 +59,14 @@ func (cmd *builderCommand) Run(name string, args []string) error {
 		return fmt.Errorf("Expected exactly one project to build, got %d", len(args))
 	}
 	custom := cmd.mapper.GetBuilderCustomizations()
-	custom.GetCommonConfiguration().Project = parameters.Args()[0]
+	config := custom.GetCommonConfiguration()
+	config.Project = parameters.Args()[0]
+	// Only the names are recorded, the values may have secrets,
+	// like credentials in remote asset URLs or in --define.
+	config.Flags = []string{}
+	parameters.Visit(func(f *flag.Flag) {
+		config.Flags = append(config.Flags, "--"+f.Name)
+	})
 	builder := proj2aci.NewBuilder(custom)
-	return builder.Run()
+	return builder.Run(ctx)
 }
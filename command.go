@@ -14,6 +14,10 @@
 
 package main
 
+import (
+	"context"
+)
+
 // command provides an interface for named actions for command line
 // purposes.
 type command interface {
@@ -21,6 +25,7 @@ type command interface {
 	// line.
 	Name() string
 	// Run should parse given args and perform some action. name
-	// parameter is given for usage purposes.
-	Run(name string, args []string) error
+	// parameter is given for usage purposes. The action should
+	// stop when ctx is done.
+	Run(ctx context.Context, name string, args []string) error
 }
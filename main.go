@@ -15,9 +15,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"sort"
+	"syscall"
 
 	"github.com/appc/goaci/proj2aci"
 )
@@ -53,12 +56,33 @@ func mainWithError() error {
 	}
 	if c, ok := commandsMap[os.Args[1]]; ok {
 		name := fmt.Sprintf("%s %s", os.Args[0], os.Args[1])
-		return c.Run(name, os.Args[2:])
+		ctx, cancel := getSignalContext()
+		defer cancel()
+		return c.Run(ctx, name, os.Args[2:])
 	} else {
 		return newCmdLineError("No such command: %q", os.Args[1])
 	}
 }
 
+// getSignalContext returns a context canceled on the first SIGINT or
+// SIGTERM, so the running command can clean up. The second signal
+// kills goaci as usual.
+func getSignalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			proj2aci.Warn(fmt.Sprintf("Got %v, cleaning up (send it again to exit immediately)", sig))
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
+
 func printUsage() {
 	fmt.Println("Available commands:")
 	commands := make([]string, 0, len(commandsMap))
@@ -45,23 +45,160 @@ func (wrapper *stringSliceWrapper) Set(str string) error {
 // commonParameterMapper maps command line parameters to
 // proj2aci.CommonConfiguration.
 type commonParameterMapper struct {
-	custom       proj2aci.BuilderCustomizations
-	config       *proj2aci.CommonConfiguration
-	execWrapper  stringSliceWrapper
-	assetWrapper stringSliceWrapper
+	custom              proj2aci.BuilderCustomizations
+	config              *proj2aci.CommonConfiguration
+	execWrapper         stringSliceWrapper
+	assetWrapper        stringSliceWrapper
+	libDirWrapper       stringSliceWrapper
+	excludeWrapper      stringSliceWrapper
+	presetWrapper       stringSliceWrapper
+	attrWrapper         stringSliceWrapper
+	mknodWrapper        stringSliceWrapper
+	libRuleWrapper      stringSliceWrapper
+	libRulesFileWrapper stringSliceWrapper
+	bundleWrapper       stringSliceWrapper
+	suppGroupWrapper    stringSliceWrapper
+	writableDirWrapper  stringSliceWrapper
+	archiveAssetWrapper stringSliceWrapper
+	defineWrapper       stringSliceWrapper
+	templateWrapper     stringSliceWrapper
+	overlayWrapper      stringSliceWrapper
+	hookWrapper         stringSliceWrapper
 }
 
 func (mapper *commonParameterMapper) setupCommonParameters(parameters *flag.FlagSet) {
 	// --exec
 	mapper.execWrapper.vector = &mapper.config.Exec
-	parameters.Var(&mapper.execWrapper, "exec", "Parameters passed to app, can be used multiple times")
+	parameters.Var(&mapper.execWrapper, "exec", "Parameters passed to app, can be used multiple times; available placeholders for use: "+mapper.getPlaceholders())
+
+	// --define
+	mapper.defineWrapper.vector = &mapper.config.Defines
+	parameters.Var(&mapper.defineWrapper, "define", "Define a placeholder <NAME> for use in assets and exec parameters, can be used multiple times; format: "+proj2aci.GetDefineString("NAME", "<value>")+"; the value can use the other available placeholders")
 
 	// --use-binary
 	parameters.StringVar(&mapper.config.UseBinary, "use-binary", "", "Which executable to put in ACI image")
 
 	// --asset
 	mapper.assetWrapper.vector = &mapper.config.Assets
-	parameters.Var(&mapper.assetWrapper, "asset", "Additional assets, can be used multiple times; format: "+proj2aci.GetAssetString("<path in ACI rootfs>", "<local path>")+"; available placeholders for use: "+mapper.getPlaceholders())
+	parameters.Var(&mapper.assetWrapper, "asset", "Additional assets, can be used multiple times; format: "+proj2aci.GetAssetString("<path in ACI rootfs>", "<local path>")+"; local path can be a glob pattern (also with ** matching any number of directories), matches are copied into the ACI directory; a trailing slash in local path means copying the contents of the directory, a trailing slash in ACI path means copying into that directory; local path can also be an http or https URL with a required checksum and an optional octal mode, like https://example.com/file#sha256=<hex>&mode=0755, the file is downloaded and cached between builds; available placeholders for use: "+mapper.getPlaceholders())
+
+	// --lib-rule
+	mapper.libRuleWrapper.vector = &mapper.config.LibRules
+	parameters.Var(&mapper.libRuleWrapper, "lib-rule", "When a library matching a pattern is copied, copy also the files matching companion patterns, can be used multiple times; format: <pattern>=<companion>[,<companion>...]; relative companion patterns are looked for in the directory of the library; built-in rules: "+strings.Join(proj2aci.GetDefaultLibRules(), " "))
+
+	// --lib-rules-file
+	mapper.libRulesFileWrapper.vector = &mapper.config.LibRulesFiles
+	parameters.Var(&mapper.libRulesFileWrapper, "lib-rules-file", "Read library rules from a file, one rule per line, lines starting with # are ignored, can be used multiple times")
+
+	// --no-default-lib-rules
+	parameters.BoolVar(&mapper.config.NoDefaultLibRules, "no-default-lib-rules", false, "Do not use the built-in library rules")
+
+	// --asset-exclude
+	mapper.excludeWrapper.vector = &mapper.config.AssetExcludes
+	parameters.Var(&mapper.excludeWrapper, "asset-exclude", "Do not copy assets matching this pattern, can be used multiple times; a pattern with a trailing slash matches only directories, a pattern with other slashes is matched against the whole path in ACI rootfs (** matches any number of directories), otherwise it is matched against the file name")
+
+	// --asset-preset
+	mapper.presetWrapper.vector = &mapper.config.AssetExcludePresets
+	parameters.Var(&mapper.presetWrapper, "asset-preset", "Do not copy assets matching a predefined list of patterns, can be used multiple times; available presets: "+strings.Join(proj2aci.GetAssetExcludePresets(), ", "))
+
+	// --asset-attr
+	mapper.attrWrapper.vector = &mapper.config.AssetAttrs
+	parameters.Var(&mapper.attrWrapper, "asset-attr", "Override attributes of files in ACI rootfs, can be used multiple times; format: "+proj2aci.GetAssetAttrString("<path or pattern in ACI rootfs>", "<attribute>=<value>", "...")+"; available attributes: mode (octal, like 0600 or 2755), uid, gid and caps (like cap_net_bind_service,cap_net_raw+ep); mode is not applied to directories matched through **; by default all files are owned by root")
+
+	// --mknod
+	mapper.mknodWrapper.vector = &mapper.config.SpecialNodes
+	parameters.Var(&mapper.mknodWrapper, "mknod", "Add a special node to ACI rootfs without requiring root privileges, can be used multiple times; format: "+proj2aci.GetSpecialNodeString("<path in ACI rootfs>", "<type>", "<type specific fields>")+"; types: c (character device) and b (block device) followed by major and minor numbers, p (FIFO) and d (empty directory), all optionally followed by an octal mode; example: "+proj2aci.GetSpecialNodeString("/dev/null", "c", "1", "3", "0666"))
+
+	// --no-dedupe
+	parameters.BoolVar(&mapper.config.NoDedupe, "no-dedupe", false, "Do not replace files with identical contents in ACI rootfs with hardlinks")
+
+	// --with
+	mapper.bundleWrapper.vector = &mapper.config.Bundles
+	parameters.Var(&mapper.bundleWrapper, "with", "Add a bundle of files commonly needed at runtime, can be used multiple times or take a comma separated list; format: <bundle>[=<directory to take the files from instead of the host or sysroot>]; available bundles: "+strings.Join(proj2aci.GetBundles(), ", "))
+
+	// --user
+	parameters.StringVar(&mapper.config.User, "user", "", "Run the app as this user (default: root); format: <uid>, <name> or <name>=<uid>; names without ids get their ids from /etc/passwd in ACI rootfs (like one copied with --with etc-files=/etc) or free ids starting from 1000 and entries for them are added to /etc/passwd in ACI rootfs; numeric ids get app entries if ACI rootfs has /etc/passwd (like with --with etc-files)")
+
+	// --group
+	parameters.StringVar(&mapper.config.Group, "group", "", "Run the app with this group (default: a group with the same name and id as the user); format: <gid>, <name> or <name>=<gid>; entries for names are added to /etc/group in ACI rootfs")
+
+	// --supplementary-group
+	mapper.suppGroupWrapper.vector = &mapper.config.SupplementaryGroups
+	parameters.Var(&mapper.suppGroupWrapper, "supplementary-group", "Run the app with this supplementary group, can be used multiple times; format is the same as in --group")
+
+	// --writable-dir
+	mapper.writableDirWrapper.vector = &mapper.config.WritableDirs
+	parameters.Var(&mapper.writableDirWrapper, "writable-dir", "Create this directory in ACI rootfs if needed and make it and its contents owned by the app's user and group, can be used multiple times")
+
+	// --strip
+	parameters.BoolVar(&mapper.config.Strip, "strip", false, "Remove symbols and debug info from ELF executables and shared libraries in ACI rootfs")
+
+	// --objcopy
+	parameters.StringVar(&mapper.config.Objcopy, "objcopy", "", "objcopy binary used for stripping, useful when building for other architecture (default: objcopy in $PATH)")
+
+	// --debug-archive
+	parameters.StringVar(&mapper.config.DebugArchive, "debug-archive", "", "Write debug info removed by --strip to this tar.gz archive, laid out as in /usr/lib/debug/.build-id")
+
+	// --debug-aci
+	parameters.StringVar(&mapper.config.DebugACI, "debug-aci", "", "Write debug info removed by --strip to this ACI, named after the app's ACI with a -debug suffix")
+
+	// --symlink-style
+	parameters.StringVar(&mapper.config.SymlinkStyle, "symlink-style", proj2aci.SymlinkStyleRelative, "How to rewrite absolute symlinks pointing into copied local directories (like the install directory), so they point to the matching paths in ACI rootfs; one of "+proj2aci.SymlinkStyleRelative+" or "+proj2aci.SymlinkStyleAbsolute)
+
+	// --allow-dangling-symlinks
+	parameters.BoolVar(&mapper.config.AllowDanglingSymlinks, "allow-dangling-symlinks", false, "Only warn about symlinks in ACI rootfs pointing to nonexistent files instead of failing")
+
+	// --on-conflict
+	parameters.StringVar(&mapper.config.OnConflict, "on-conflict", proj2aci.ConflictError, "What to do when different files are copied to the same path in ACI rootfs; one of "+proj2aci.ConflictError+", "+proj2aci.ConflictFirst+" (keep the file copied first) or "+proj2aci.ConflictLast+" (keep the file copied last); files with the same contents do not conflict")
+
+	// --explain-assets
+	parameters.StringVar(&mapper.config.ExplainAssets, "explain-assets", "", "Print why each asset was copied to ACI rootfs (explicitly, as a shared library, because of a library rule and so on); one of "+proj2aci.ExplainFormatTree+" or "+proj2aci.ExplainFormatDot+" (Graphviz)")
+
+	// --explain-assets-file
+	parameters.StringVar(&mapper.config.ExplainAssetsFile, "explain-assets-file", "", "Write the --explain-assets output to this local file instead of stdout, required with --log-format="+proj2aci.LogFormatJSON)
+
+	// --relative-assets
+	parameters.StringVar(&mapper.config.RelativeAssets, "relative-assets", "", "Allow relative local paths in assets and resolve them against the current working directory ("+proj2aci.RelativeAssetsCwd+") or the project checkout ("+proj2aci.RelativeAssetsProject+"); relative paths in ACI rootfs are always resolved against the directory of the app binary")
+
+	// --template-asset
+	mapper.templateWrapper.vector = &mapper.config.TemplateAssets
+	parameters.Var(&mapper.templateWrapper, "template-asset", "Render a local file with Go's text/template and copy it to ACI rootfs, can be used multiple times; format: "+proj2aci.GetAssetString("<path in ACI rootfs>", "<local template>")+"; available data: .Project, .Builder, .Revision, .Version (the VERSION define), .Labels and .Defines (maps); available functions: env, join, lower and upper")
+
+	// --overlay
+	mapper.overlayWrapper.vector = &mapper.config.Overlays
+	parameters.Var(&mapper.overlayWrapper, "overlay", "Copy the contents of a local directory mirroring ACI rootfs layout after all the other assets, can be used multiple times, the overlays are copied in the given order and their files replace the ones copied before (whatever --on-conflict says); --asset-exclude and --asset-attr apply to the copied files")
+
+	// --hook
+	mapper.hookWrapper.vector = &mapper.config.Hooks
+	parameters.Var(&mapper.hookWrapper, "hook", "Run a shell command at a phase of the build, can be used multiple times; format: "+proj2aci.GetHookString("<phase>", "<command>")+"; phases in the order they run: "+strings.Join(proj2aci.GetHookPhases(), ", ")+" (the prepare phases are skipped when reusing the tmp dir); the command gets GOACI_TMPDIR, GOACI_ACIDIR, GOACI_ROOTFS, GOACI_PHASE and GOACI_BUILDER environment variables and GOACI_<NAME> for each placeholder <NAME>")
+
+	// --build-info-file
+	parameters.StringVar(&mapper.config.BuildInfoFile, "build-info-file", "", "Write a JSON file with the project, its revision, the builder, the tool versions, the names of the given flags and the build time (SOURCE_DATE_EPOCH if set) to this path in ACI rootfs, like /etc/goaci/build.json")
+
+	// --archive-asset
+	mapper.archiveAssetWrapper.vector = &mapper.config.ArchiveAssets
+	parameters.Var(&mapper.archiveAssetWrapper, "archive-asset", "Extract an archive (.tar, .tar.gz, .tgz, .tar.bz2, .tbz2, .tar.xz, .txz or .zip) into a directory in ACI rootfs, can be used multiple times; format: "+proj2aci.GetArchiveAssetString("<directory in ACI rootfs>", "<local archive>", "<option>", "...")+"; options: strip-components=<number> removes leading path elements, include=<pattern> and exclude=<pattern> (can be used multiple times, same patterns as in --asset-exclude) select the extracted files; extracted executables get their shared libraries copied too")
+
+	// --asset-cache-dir
+	parameters.StringVar(&mapper.config.AssetCacheDir, "asset-cache-dir", "", "Cache downloaded remote assets in this directory, by default goaci/assets in the user's cache directory")
+
+	// --timeout
+	parameters.DurationVar(&mapper.config.Timeout, "timeout", 0, "Stop the build if it takes longer than this (like 30m), running commands are killed; 0 means no timeout")
+
+	// --phase-timeout
+	parameters.DurationVar(&mapper.config.PhaseTimeout, "phase-timeout", 0, "Stop the build if any of its phases (like preparing the project, copying assets, stripping binaries or running hooks) takes longer than this; 0 means no timeout")
+
+	// --log-format
+	parameters.StringVar(&mapper.config.LogFormat, "log-format", proj2aci.LogFormatText, "Format of the log messages; one of "+proj2aci.LogFormatText+" or "+proj2aci.LogFormatJSON+" (an object per line with time, level, msg, builder, project and phase keys)")
+
+	// --quiet
+	parameters.BoolVar(&mapper.config.Quiet, "quiet", false, "Log only warnings")
+
+	// --verbose
+	parameters.BoolVar(&mapper.config.Verbose, "verbose", false, "Log debug messages too, like setting GOACI_DEBUG")
+
+	// --log-dir
+	parameters.StringVar(&mapper.config.LogDir, "log-dir", "", "Write the output of the commands run in each phase of the build (like the go or make invocations, hooks and objcopy) to <phase>.log files in this directory instead of the terminal")
 
 	// --keep-tmp-dir
 	parameters.BoolVar(&mapper.config.KeepTmpDir, "keep-tmp-dir", false, "Do not delete temporary directory used for creating ACI")
@@ -71,6 +208,13 @@ func (mapper *commonParameterMapper) setupCommonParameters(parameters *flag.Flag
 
 	// --reuse-tmp-dir
 	parameters.StringVar(&mapper.config.ReuseTmpDir, "reuse-tmp-dir", "", "Use this already existing directory with built project to build an ACI image; ACI specific contents in this directory are removed before reuse")
+
+	// --sysroot
+	parameters.StringVar(&mapper.config.Sysroot, "sysroot", "", "Look for shared libraries needed by assets in this directory instead of /, useful when building for other architecture")
+
+	// --lib-dir
+	mapper.libDirWrapper.vector = &mapper.config.LibDirs
+	parameters.Var(&mapper.libDirWrapper, "lib-dir", "Additional directory inside sysroot to search for shared libraries (like LD_LIBRARY_PATH), can be used multiple times")
 }
 
 func (mapper *commonParameterMapper) getPlaceholders() string {
@@ -80,6 +224,7 @@ func (mapper *commonParameterMapper) getPlaceholders() string {
 		placeholders = append(placeholders, p)
 	}
 	sort.Strings(placeholders)
+	placeholders = append(placeholders, "<ENV:VAR> (value of an environment variable)", "user-defined <NAME> (see --define)", "<< for a literal < (like <<b>)")
 	return strings.Join(placeholders, ", ")
 }
 
@@ -0,0 +1,449 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// basePasswd and baseGroup are the contents of /etc/passwd
+	// and /etc/group written to the ACI rootfs if they are not
+	// provided by assets.
+	basePasswd = "root:x:0:0:root:/root:/bin/sh\nnobody:x:65534:65534:nobody:/nonexistent:/sbin/nologin\n"
+	baseGroup  = "root:x:0:\nnobody:x:65534:\n"
+	// firstAutoID is the first id given to accounts specified
+	// only by name.
+	firstAutoID = 1000
+	// numericAccountName is the name of the entries generated for
+	// the user and the group specified only by id.
+	numericAccountName = "app"
+)
+
+// wellKnownUsers and wellKnownGroups are the accounts which get their
+// usual ids when specified only by name.
+var (
+	wellKnownUsers = map[string]int{
+		"root":   0,
+		"nobody": 65534,
+	}
+	wellKnownGroups = map[string]int{
+		"root":    0,
+		"nobody":  65534,
+		"nogroup": 65534,
+	}
+)
+
+// account is a user or a group. The name may be empty if the account
+// was specified only by id. A negative id means that it was not
+// specified and it is yet to be assigned.
+type account struct {
+	name string
+	id   int
+}
+
+// accounts describes the user and the groups the app is run as.
+type accounts struct {
+	user          account
+	group         account
+	supplementary []account
+}
+
+// parseAccount parses an account specification, which is either a
+// numeric id, a name or a name followed by = and an id.
+func parseAccount(spec string) (account, error) {
+	if isNumericID(spec) {
+		id, err := strconv.Atoi(spec)
+		if err != nil {
+			return account{}, fmt.Errorf("Invalid id %q: %v", spec, err)
+		}
+		return account{id: id}, nil
+	}
+	kv := strings.SplitN(spec, "=", 2)
+	a := account{
+		name: kv[0],
+		id:   -1,
+	}
+	if !isValidAccountName(a.name) {
+		return account{}, fmt.Errorf("Invalid account name %q", a.name)
+	}
+	if len(kv) == 2 {
+		id, err := strconv.Atoi(kv[1])
+		if err != nil || id < 0 {
+			return account{}, fmt.Errorf("Invalid id %q of account %q, expected a nonnegative number", kv[1], a.name)
+		}
+		a.id = id
+	}
+	return a, nil
+}
+
+// isValidAccountName checks if name can be put into /etc/passwd or
+// /etc/group.
+func isValidAccountName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return false
+	}
+	return !strings.ContainsAny(name, ":,=\n\t /")
+}
+
+// existingAccounts are the accounts found in /etc/passwd and
+// /etc/group in the ACI rootfs, mapping the names to the ids.
+type existingAccounts struct {
+	users  map[string]int
+	groups map[string]int
+}
+
+// readExistingAccounts reads the accounts from /etc/passwd and
+// /etc/group in rootfs. Missing files have no accounts.
+func readExistingAccounts(rootfs string) (*existingAccounts, error) {
+	users, err := readAccountsFile(filepath.Join(rootfs, "etc", "passwd"))
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readAccountsFile(filepath.Join(rootfs, "etc", "group"))
+	if err != nil {
+		return nil, err
+	}
+	return &existingAccounts{users: users, groups: groups}, nil
+}
+
+// readAccountsFile returns the names and the ids of the entries of a
+// file in /etc/passwd or /etc/group format.
+func readAccountsFile(path string) (map[string]int, error) {
+	ids := map[string]int{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ids, nil
+	} else if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 {
+			continue
+		}
+		if id, err := strconv.Atoi(fields[2]); err == nil {
+			ids[fields[0]] = id
+		}
+	}
+	return ids, nil
+}
+
+// parseAccounts parses the specifications of the user, the group and
+// the supplementary groups. An empty user means root, an empty group
+// means the group of the same name and id as the user. Accounts
+// specified only by name get the ids they have in the existing
+// accounts (may be nil), their usual ids if they are well known, or
+// free ids starting from 1000, not used by the existing accounts.
+func parseAccounts(user, group string, supplementary []string, existing *existingAccounts) (*accounts, error) {
+	if existing == nil {
+		existing = &existingAccounts{}
+	}
+	accts := &accounts{}
+	var err error
+	if user == "" {
+		user = "0"
+	}
+	if accts.user, err = parseAccount(user); err != nil {
+		return nil, err
+	}
+	if group == "" {
+		accts.group = account{name: accts.user.name, id: accts.user.id}
+	} else if accts.group, err = parseAccount(group); err != nil {
+		return nil, err
+	}
+	for _, spec := range supplementary {
+		g, err := parseAccount(spec)
+		if err != nil {
+			return nil, err
+		}
+		accts.supplementary = append(accts.supplementary, g)
+	}
+
+	usedUIDs := map[int]bool{}
+	for _, id := range existing.users {
+		usedUIDs[id] = true
+	}
+	if accts.user.id >= 0 {
+		usedUIDs[accts.user.id] = true
+	}
+	groups := accts.getGroups()
+	usedGIDs := map[int]bool{}
+	for _, id := range existing.groups {
+		usedGIDs[id] = true
+	}
+	named := map[string]int{}
+	for _, g := range groups {
+		if g.id < 0 {
+			continue
+		}
+		usedGIDs[g.id] = true
+		if g.name == "" {
+			continue
+		}
+		if id, ok := named[g.name]; ok && id != g.id {
+			return nil, fmt.Errorf("Group %q specified with different ids %d and %d", g.name, id, g.id)
+		}
+		named[g.name] = g.id
+	}
+	if accts.user.id < 0 {
+		if id, ok := existing.users[accts.user.name]; ok {
+			accts.user.id = id
+		} else if id, ok := wellKnownUsers[accts.user.name]; ok {
+			accts.user.id = id
+		} else {
+			accts.user.id = getFreeID(usedUIDs)
+		}
+	}
+	for _, g := range groups {
+		if g.id >= 0 {
+			continue
+		}
+		if id, ok := named[g.name]; ok {
+			g.id = id
+			continue
+		}
+		if id, ok := existing.groups[g.name]; ok {
+			g.id = id
+		} else if id, ok := wellKnownGroups[g.name]; ok {
+			g.id = id
+		} else if g.name == accts.user.name && !usedGIDs[accts.user.id] {
+			// prefer the same id for the user and its group
+			g.id = accts.user.id
+		} else {
+			g.id = getFreeID(usedGIDs)
+		}
+		usedGIDs[g.id] = true
+		named[g.name] = g.id
+	}
+	return accts, nil
+}
+
+// getGroups returns pointers to the primary group and the
+// supplementary groups.
+func (accts *accounts) getGroups() []*account {
+	groups := []*account{&accts.group}
+	for i := range accts.supplementary {
+		groups = append(groups, &accts.supplementary[i])
+	}
+	return groups
+}
+
+func getFreeID(used map[int]bool) int {
+	id := firstAutoID
+	for used[id] {
+		id++
+	}
+	used[id] = true
+	return id
+}
+
+// getSupplementaryGIDs returns the ids of the supplementary groups.
+func (accts *accounts) getSupplementaryGIDs() []int {
+	if len(accts.supplementary) == 0 {
+		return nil
+	}
+	gids := make([]int, 0, len(accts.supplementary))
+	for _, g := range accts.supplementary {
+		gids = append(gids, g.id)
+	}
+	return gids
+}
+
+// hasNames checks if any of the accounts was specified by name, so
+// the entries for it need to be added to /etc/passwd or /etc/group.
+func (accts *accounts) hasNames() bool {
+	for _, g := range accts.getGroups() {
+		if g.name != "" {
+			return true
+		}
+	}
+	return accts.user.name != ""
+}
+
+// accountEntry is a line of /etc/passwd or /etc/group. idOnly means
+// that the account was specified only by id, so the entry is not
+// needed if the file has some entry with that id already.
+type accountEntry struct {
+	fields []string
+	idOnly bool
+}
+
+// getAccountEntries returns the entries of the user and the groups.
+// The accounts specified only by id get entries named "app" (or
+// "app<gid>" for the supplementary groups), so the app does not run
+// as an id without a name.
+func (accts *accounts) getAccountEntries() ([]accountEntry, []accountEntry) {
+	userName := accts.user.name
+	if userName == "" {
+		userName = numericAccountName
+	}
+	passwdEntries := []accountEntry{{
+		fields: []string{
+			userName,
+			"x",
+			strconv.Itoa(accts.user.id),
+			strconv.Itoa(accts.group.id),
+			userName,
+			"/nonexistent",
+			"/sbin/nologin",
+		},
+		idOnly: accts.user.name == "",
+	}}
+	groupEntries := []accountEntry{}
+	for i, g := range accts.getGroups() {
+		name := g.name
+		if name == "" {
+			name = numericAccountName
+			if i > 0 {
+				name = fmt.Sprintf("%s%d", numericAccountName, g.id)
+			}
+		}
+		members := ""
+		if i > 0 {
+			members = userName
+		}
+		groupEntries = append(groupEntries, accountEntry{
+			fields: []string{name, "x", strconv.Itoa(g.id), members},
+			idOnly: g.name == "",
+		})
+	}
+	return passwdEntries, groupEntries
+}
+
+// prepareAccounts adds the entries for the accounts to /etc/passwd
+// and /etc/group in rootfs. If some account was specified by name,
+// the files are created if they were not copied there by assets (or
+// the etc-files bundle). Otherwise the entries of the numeric ids are
+// added only to the existing files.
+func prepareAccounts(rootfs string, accts *accounts) error {
+	passwdEntries, groupEntries := accts.getAccountEntries()
+	create := accts.hasNames()
+	if err := addAccountEntries(filepath.Join(rootfs, "etc", "passwd"), basePasswd, passwdEntries, create); err != nil {
+		return err
+	}
+	return addAccountEntries(filepath.Join(rootfs, "etc", "group"), baseGroup, groupEntries, create)
+}
+
+// addAccountEntries adds the entries to a file in /etc/passwd or
+// /etc/group format, unless they are already there. If the file does
+// not exist, it is created with base contents first if create is
+// true. The file is replaced, not modified in place, because it may
+// be hardlinked to some other file in the rootfs.
+func addAccountEntries(path, base string, entries []accountEntry, create bool) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	contents := base
+	if data, err := ioutil.ReadFile(path); err == nil {
+		contents = string(data)
+	} else if !os.IsNotExist(err) {
+		return err
+	} else if !create {
+		return nil
+	}
+	existing := map[string]string{}
+	existingIDs := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) > 2 {
+			existing[fields[0]] = fields[2]
+			existingIDs[fields[2]] = true
+		}
+	}
+	if contents != "" && !strings.HasSuffix(contents, "\n") {
+		contents += "\n"
+	}
+	added := false
+	for _, entry := range entries {
+		name, id := entry.fields[0], entry.fields[2]
+		if entry.idOnly && existingIDs[id] {
+			continue
+		}
+		if existingID, ok := existing[name]; ok {
+			if existingID != id {
+				return fmt.Errorf("Account %q already exists in %q with id %s, not %s", name, path, existingID, id)
+			}
+			continue
+		}
+		Debug("adding ", name, " to ", path)
+		contents += strings.Join(entry.fields, ":") + "\n"
+		existing[name] = id
+		existingIDs[id] = true
+		added = true
+	}
+	if !added {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".goaci-tmp"
+	if err := ioutil.WriteFile(tmp, []byte(contents), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// prepareWritableDirs creates the writable directories in rootfs and
+// returns the asset attributes making them and their contents owned
+// by the app's user and group.
+func prepareWritableDirs(rootfs string, dirs []string, accts *accounts) ([]*assetAttr, error) {
+	attrs := make([]*assetAttr, 0, len(dirs))
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(rootfs, dir), 0755); err != nil {
+			return nil, err
+		}
+		uid := accts.user.id
+		gid := accts.group.id
+		attrs = append(attrs, &assetAttr{
+			pattern: filepath.Join(dir, recursiveWildcard),
+			uid:     &uid,
+			gid:     &gid,
+		})
+	}
+	return attrs, nil
+}
+
+// validateWritableDirs checks if the writable directories are
+// absolute paths without glob patterns.
+func validateWritableDirs(dirs []string) error {
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) || filepath.Clean(dir) == "/" || hasGlobMeta(dir) {
+			return fmt.Errorf("Writable directory %q has to be an absolute path other than the root directory and can't contain glob patterns", dir)
+		}
+	}
+	return nil
+}
+
+func isNumericID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
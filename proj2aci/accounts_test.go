@@ -0,0 +1,317 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseAccounts(t *testing.T) {
+	tests := []struct {
+		user          string
+		group         string
+		supplementary []string
+		expected      *accounts
+		fail          bool
+	}{
+		{
+			expected: &accounts{user: account{id: 0}, group: account{id: 0}},
+		},
+		{
+			user: "1000", group: "1001",
+			expected: &accounts{user: account{id: 1000}, group: account{id: 1001}},
+		},
+		{
+			user:     "web",
+			expected: &accounts{user: account{name: "web", id: 1000}, group: account{name: "web", id: 1000}},
+		},
+		{
+			user: "web=500", group: "staff", supplementary: []string{"audio=29", "video"},
+			expected: &accounts{
+				user:          account{name: "web", id: 500},
+				group:         account{name: "staff", id: 1000},
+				supplementary: []account{{name: "audio", id: 29}, {name: "video", id: 1001}},
+			},
+		},
+		{
+			user: "nobody", group: "nogroup",
+			expected: &accounts{user: account{name: "nobody", id: 65534}, group: account{name: "nogroup", id: 65534}},
+		},
+		{
+			// the user's group gets a free id if the user's id
+			// is taken by another group
+			user: "web", group: "web", supplementary: []string{"1000"},
+			expected: &accounts{
+				user:          account{name: "web", id: 1000},
+				group:         account{name: "web", id: 1001},
+				supplementary: []account{{id: 1000}},
+			},
+		},
+		{user: "web=-1", fail: true},
+		{user: "web=x", fail: true},
+		{user: "a:b", fail: true},
+		{user: "-web", fail: true},
+		{group: "bad/name", fail: true},
+		{user: "web", group: "staff=10", supplementary: []string{"staff=11"}, fail: true},
+	}
+	for _, tt := range tests {
+		accts, err := parseAccounts(tt.user, tt.group, tt.supplementary, nil)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q %q %v: expected an error", tt.user, tt.group, tt.supplementary)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q %q %v: unexpected error: %v", tt.user, tt.group, tt.supplementary, err)
+			continue
+		}
+		if !reflect.DeepEqual(accts, tt.expected) {
+			t.Errorf("%q %q %v: expected %+v, got %+v", tt.user, tt.group, tt.supplementary, tt.expected, accts)
+		}
+	}
+}
+
+func TestPrepareAccounts(t *testing.T) {
+	tests := []struct {
+		name          string
+		user          string
+		group         string
+		supplementary []string
+		// existing means that /etc/passwd and /etc/group are
+		// in rootfs before, like with the etc-files bundle
+		existing bool
+		passwd   string
+		groupF   string
+	}{
+		{
+			name: "numeric ids with etc files",
+			user: "1000", group: "1000", existing: true,
+			passwd: basePasswd + "app:x:1000:1000:app:/nonexistent:/sbin/nologin\n",
+			groupF: baseGroup + "app:x:1000:\n",
+		},
+		{
+			name: "numeric ids with supplementary group",
+			user: "1000", group: "1001", supplementary: []string{"2000"}, existing: true,
+			passwd: basePasswd + "app:x:1000:1001:app:/nonexistent:/sbin/nologin\n",
+			groupF: baseGroup + "app:x:1001:\napp2000:x:2000:app\n",
+		},
+		{
+			name: "numeric ids without etc files",
+			user: "1000", group: "1000",
+		},
+		{
+			name:     "root with etc files",
+			existing: true,
+			passwd:   basePasswd,
+			groupF:   baseGroup,
+		},
+		{
+			name: "nobody with etc files",
+			user: "65534", existing: true,
+			passwd: basePasswd,
+			groupF: baseGroup,
+		},
+		{
+			name: "names",
+			user: "web", supplementary: []string{"audio=29"},
+			passwd: basePasswd + "web:x:1000:1000:web:/nonexistent:/sbin/nologin\n",
+			groupF: baseGroup + "web:x:1000:\naudio:x:29:web\n",
+		},
+		{
+			name: "named group with numeric user",
+			user: "1000", group: "staff", existing: true,
+			passwd: basePasswd + "app:x:1000:1000:app:/nonexistent:/sbin/nologin\n",
+			groupF: baseGroup + "staff:x:1000:\n",
+		},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "goaci-accounts-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		passwdPath := filepath.Join(dir, "etc", "passwd")
+		groupPath := filepath.Join(dir, "etc", "group")
+		if tt.existing {
+			if err := os.MkdirAll(filepath.Join(dir, "etc"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(passwdPath, []byte(basePasswd), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(groupPath, []byte(baseGroup), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		existing, err := readExistingAccounts(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accts, err := parseAccounts(tt.user, tt.group, tt.supplementary, existing)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := prepareAccounts(dir, accts); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		for _, f := range []struct {
+			path     string
+			expected string
+		}{{passwdPath, tt.passwd}, {groupPath, tt.groupF}} {
+			data, err := ioutil.ReadFile(f.path)
+			if f.expected == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("%s: expected no %s, got %q (%v)", tt.name, f.path, data, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			if string(data) != f.expected {
+				t.Errorf("%s: expected %s:\n%s\ngot:\n%s", tt.name, filepath.Base(f.path), f.expected, data)
+			}
+		}
+	}
+}
+
+func TestPrepareAccountsConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goaci-accounts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	accts, err := parseAccounts("root=5", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareAccounts(dir, accts); err == nil {
+		t.Error("expected an error for root with a different id")
+	}
+}
+
+// TestPrepareAccountsExisting checks that the accounts given by name
+// get the ids they have in the files copied to rootfs and that the
+// free ids are not taken by those files.
+func TestPrepareAccountsExisting(t *testing.T) {
+	imagePasswd := basePasswd + "www-data:x:33:33:www-data:/var/www:/sbin/nologin\nalice:x:1000:1000::/home/alice:/bin/sh\n"
+	imageGroup := baseGroup + "www-data:x:33:\nalice:x:1000:\nstaff:x:50:\n"
+	tests := []struct {
+		name          string
+		user          string
+		group         string
+		supplementary []string
+		uid           int
+		gid           int
+		supGIDs       []int
+		passwd        string
+		groupF        string
+	}{
+		{
+			name: "existing user and group",
+			user: "www-data",
+			uid:  33, gid: 33,
+			passwd: imagePasswd,
+			groupF: imageGroup,
+		},
+		{
+			name: "existing supplementary group",
+			user: "www-data", supplementary: []string{"staff"},
+			uid: 33, gid: 33, supGIDs: []int{50},
+			passwd: imagePasswd,
+			groupF: imageGroup,
+		},
+		{
+			name: "new user avoids the existing ids",
+			user: "web", supplementary: []string{"video"},
+			uid: 1001, gid: 1001, supGIDs: []int{1002},
+			passwd: imagePasswd + "web:x:1001:1001:web:/nonexistent:/sbin/nologin\n",
+			groupF: imageGroup + "web:x:1001:\nvideo:x:1002:web\n",
+		},
+		{
+			name: "new user in an existing group",
+			user: "web", group: "staff",
+			uid: 1001, gid: 50,
+			passwd: imagePasswd + "web:x:1001:50:web:/nonexistent:/sbin/nologin\n",
+			groupF: imageGroup,
+		},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "goaci-accounts-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		passwdPath := filepath.Join(dir, "etc", "passwd")
+		groupPath := filepath.Join(dir, "etc", "group")
+		if err := os.MkdirAll(filepath.Join(dir, "etc"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(passwdPath, []byte(imagePasswd), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(groupPath, []byte(imageGroup), 0644); err != nil {
+			t.Fatal(err)
+		}
+		existing, err := readExistingAccounts(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accts, err := parseAccounts(tt.user, tt.group, tt.supplementary, existing)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if accts.user.id != tt.uid || accts.group.id != tt.gid || !reflect.DeepEqual(accts.getSupplementaryGIDs(), tt.supGIDs) {
+			t.Errorf("%s: expected ids %d, %d and %v, got %d, %d and %v", tt.name, tt.uid, tt.gid, tt.supGIDs, accts.user.id, accts.group.id, accts.getSupplementaryGIDs())
+		}
+		if err := prepareAccounts(dir, accts); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		for _, f := range []struct {
+			path     string
+			expected string
+		}{{passwdPath, tt.passwd}, {groupPath, tt.groupF}} {
+			data, err := ioutil.ReadFile(f.path)
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			if string(data) != f.expected {
+				t.Errorf("%s: expected %s:\n%s\ngot:\n%s", tt.name, filepath.Base(f.path), f.expected, data)
+			}
+		}
+		// resolving the accounts again gives the same ids
+		existing, err = readExistingAccounts(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := parseAccounts(tt.user, tt.group, tt.supplementary, existing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(again, accts) {
+			t.Errorf("%s: expected the same accounts %+v, got %+v", tt.name, accts, again)
+		}
+	}
+}
@@ -0,0 +1,531 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// archiveFormats maps the suffixes of the supported archive file
+// names to their formats.
+var archiveFormats = []struct {
+	suffix string
+	format string
+}{
+	{".tar", "tar"},
+	{".tar.gz", "tar.gz"},
+	{".tgz", "tar.gz"},
+	{".tar.bz2", "tar.bz2"},
+	{".tbz2", "tar.bz2"},
+	{".tar.xz", "tar.xz"},
+	{".txz", "tar.xz"},
+	{".zip", "zip"},
+}
+
+// archiveAsset is an archive extracted into a directory in the ACI
+// rootfs.
+type archiveAsset struct {
+	aci     string
+	archive string
+	format  string
+	// stripComponents is the number of leading path elements
+	// removed from the archive entries.
+	stripComponents int
+	// includes are the patterns of the entries to extract, all
+	// of them if nil.
+	includes *pathFilter
+	excludes *pathFilter
+}
+
+// GetArchiveAssetString returns a properly formatted archive asset
+// string.
+func GetArchiveAssetString(aciDir, archive string, options ...string) string {
+	return strings.Join(append([]string{aciDir, archive}, options...), listSeparator())
+}
+
+// parseArchiveAssets parses archive asset specifications. Each
+// specification is a directory in the ACI rootfs, a path to the
+// archive and optional options, all separated with the path list
+// separator, like
+// "/opt/vendor:/tmp/vendor-1.0.tar.gz:strip-components=1:exclude=doc/".
+// The include and exclude options can be used many times, they take
+// the same patterns as --asset-exclude, matched against the paths in
+// the archive after stripping the leading elements. Relative paths
+// are resolved like the relative paths of the other assets.
+func parseArchiveAssets(specs []string) ([]*archiveAsset, error) {
+	assets := make([]*archiveAsset, 0, len(specs))
+	for _, spec := range specs {
+		asset, err := parseArchiveAsset(spec)
+		if err != nil {
+			return nil, fmt.Errorf("Malformed archive asset %q: %v", spec, err)
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+func parseArchiveAsset(spec string) (*archiveAsset, error) {
+	fields := filepath.SplitList(spec)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("expected a directory in ACI rootfs and an archive separated with %v", listSeparator())
+	}
+	asset := &archiveAsset{
+		aci:     fields[0],
+		archive: fields[1],
+		format:  getArchiveFormat(fields[1]),
+	}
+	if asset.format == "" {
+		suffixes := make([]string, 0, len(archiveFormats))
+		for _, f := range archiveFormats {
+			suffixes = append(suffixes, f.suffix)
+		}
+		return nil, fmt.Errorf("unknown archive format, expected one of %s", strings.Join(suffixes, ", "))
+	}
+	includes := []string{}
+	excludes := []string{}
+	for _, field := range fields[2:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected key=value, got %q", field)
+		}
+		switch key, value := kv[0], kv[1]; key {
+		case "strip-components":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid strip-components %q, expected a non-negative number", value)
+			}
+			asset.stripComponents = n
+		case "include":
+			includes = append(includes, value)
+		case "exclude":
+			excludes = append(excludes, value)
+		default:
+			return nil, fmt.Errorf("unknown option %q, expected strip-components, include or exclude", key)
+		}
+	}
+	var err error
+	if len(includes) > 0 {
+		if asset.includes, err = newPathFilter(includes); err != nil {
+			return nil, err
+		}
+	}
+	if asset.excludes, err = newPathFilter(excludes); err != nil {
+		return nil, err
+	}
+	return asset, nil
+}
+
+// getArchiveFormat returns the format of the archive based on its
+// name, an empty string if it is not known.
+func getArchiveFormat(name string) string {
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(name, f.suffix) {
+			return f.format
+		}
+	}
+	return ""
+}
+
+// extract extracts the archive into dir and returns the assets for
+// copying the extracted files to the ACI rootfs. Every file gets its
+// own asset, so the extracted executables and libraries get their
+// dependencies copied too. Entries escaping dir (absolute paths,
+// paths with "..", paths going through previously extracted
+// symlinks) are rejected.
+func (a *archiveAsset) extract(ctx context.Context, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	x := &archiveExtractor{
+		asset: a,
+		root:  dir,
+		dirs:  make(map[string]struct{}),
+	}
+	var err error
+	switch a.format {
+	case "zip":
+		err = x.extractZip()
+	default:
+		err = x.extractTar(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to extract %q: %v", a.archive, err)
+	}
+	return x.getAssets()
+}
+
+// archiveExtractor keeps the state of extracting a single archive.
+type archiveExtractor struct {
+	asset *archiveAsset
+	root  string
+	// files are the extracted non-directories, relative to root.
+	files []string
+	// dirs are the extracted directories, relative to root.
+	dirs map[string]struct{}
+}
+
+func (x *archiveExtractor) extractTar(ctx context.Context) error {
+	r, cleanup, err := x.openTar(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, ok, err := x.getEntryPath(hdr.Name, hdr.Typeflag == tar.TypeDir)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.writeDir(rel, mode)
+		case tar.TypeReg, tar.TypeRegA:
+			err = x.writeFile(rel, mode, tr)
+		case tar.TypeSymlink:
+			err = x.writeSymlink(rel, hdr.Linkname)
+		case tar.TypeLink:
+			err = x.writeHardlink(rel, hdr.Linkname)
+		default:
+			Warn(fmt.Sprintf("Skipping %q in %q, unsupported entry type", hdr.Name, x.asset.archive))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// openTar returns a reader of the uncompressed tar archive and a
+// function closing it. xz archives are decompressed with the xz tool
+// into a temporary file, there is no xz support in the standard
+// library.
+func (x *archiveExtractor) openTar(ctx context.Context) (io.Reader, func(), error) {
+	if x.asset.format == "tar.xz" {
+		tmp, err := ioutil.TempFile(filepath.Dir(x.root), "archive-")
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup := func() {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+		_, stderr := getCmdOutput(ctx)
+		if err := RunCmdFull(ctx, "", []string{"xz", "-dc", x.asset.archive}, nil, "", tmp, stderr); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return tmp, cleanup, nil
+	}
+	f, err := os.Open(x.asset.archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { f.Close() }
+	switch x.asset.format {
+	case "tar.gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return gz, cleanup, nil
+	case "tar.bz2":
+		return bzip2.NewReader(f), cleanup, nil
+	}
+	return f, cleanup, nil
+}
+
+func (x *archiveExtractor) extractZip() error {
+	zr, err := zip.OpenReader(x.asset.archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		rel, ok, err := x.getEntryPath(zf.Name, mode.IsDir())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		switch {
+		case mode.IsDir():
+			err = x.writeDir(rel, mode.Perm())
+		case mode.IsRegular():
+			err = x.writeZipFile(rel, zf)
+		case isSymlink(mode):
+			err = x.writeZipSymlink(rel, zf)
+		default:
+			Warn(fmt.Sprintf("Skipping %q in %q, unsupported entry type", zf.Name, x.asset.archive))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *archiveExtractor) writeZipFile(rel string, zf *zip.File) error {
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return x.writeFile(rel, zf.Mode().Perm(), r)
+}
+
+func (x *archiveExtractor) writeZipSymlink(rel string, zf *zip.File) error {
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	target, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return x.writeSymlink(rel, string(target))
+}
+
+// getEntryPath returns the path of the archive entry relative to the
+// extraction directory, with the leading elements stripped. It
+// returns false if the entry should be skipped and an error if the
+// entry would escape the extraction directory.
+func (x *archiveExtractor) getEntryPath(name string, isDir bool) (string, bool, error) {
+	elems, err := getArchiveEntryElems(name)
+	if err != nil {
+		return "", false, err
+	}
+	if len(elems) <= x.asset.stripComponents {
+		return "", false, nil
+	}
+	elems = elems[x.asset.stripComponents:]
+	rel := filepath.Join(elems...)
+	if !x.isIncluded(elems, isDir) {
+		Debug("skipping ", name, " in ", x.asset.archive)
+		return "", false, nil
+	}
+	if err := checkNoSymlinks(x.root, filepath.Dir(rel)); err != nil {
+		return "", false, fmt.Errorf("Archive entry %q: %v", name, err)
+	}
+	return rel, true, nil
+}
+
+// getArchiveEntryElems splits the name of an archive entry into path
+// elements. Absolute names and names with ".." elements are errors.
+func getArchiveEntryElems(name string) ([]string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) {
+		return nil, fmt.Errorf("Archive entry %q has an absolute path", name)
+	}
+	elems := []string{}
+	for _, elem := range splitPath(name) {
+		switch elem {
+		case ".":
+			continue
+		case "..":
+			return nil, fmt.Errorf("Archive entry %q points outside of the archive", name)
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// isIncluded checks the entry and its parent directories against the
+// include and exclude patterns, so excluding a directory excludes
+// everything inside it and including a directory includes everything
+// inside it. Directories leading to included entries are created
+// anyway.
+func (x *archiveExtractor) isIncluded(elems []string, isDir bool) bool {
+	included := x.asset.includes == nil
+	for i := 1; i <= len(elems); i++ {
+		path := "/" + filepath.Join(elems[:i]...)
+		dir := isDir || i < len(elems)
+		if x.asset.excludes.excludes(path, dir) {
+			return false
+		}
+		if !included && x.asset.includes.excludes(path, dir) {
+			included = true
+		}
+	}
+	return included
+}
+
+// checkNoSymlinks checks that none of the path elements of rel is a
+// symlink, so nothing is written outside of root.
+func checkNoSymlinks(root, rel string) error {
+	path := root
+	for _, elem := range splitPath(rel) {
+		path = filepath.Join(path, elem)
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isSymlink(fi.Mode()) {
+			return fmt.Errorf("path goes through a symlink %q", elem)
+		}
+	}
+	return nil
+}
+
+// prepareEntry creates the parent directories of the entry and
+// removes the old entry, if any.
+func (x *archiveExtractor) prepareEntry(rel string) (string, error) {
+	path := filepath.Join(x.root, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+		x.dirs[dir] = struct{}{}
+	}
+	if fi, err := os.Lstat(path); err == nil && !fi.IsDir() {
+		if err := os.Remove(path); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+func (x *archiveExtractor) writeDir(rel string, mode os.FileMode) error {
+	path, err := x.prepareEntry(rel)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	x.dirs[rel] = struct{}{}
+	// keep the directory writable for extracting its contents
+	return os.Chmod(path, mode|0700)
+}
+
+func (x *archiveExtractor) writeFile(rel string, mode os.FileMode, r io.Reader) error {
+	path, err := x.prepareEntry(rel)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	x.files = append(x.files, rel)
+	return f.Close()
+}
+
+func (x *archiveExtractor) writeSymlink(rel, target string) error {
+	path, err := x.prepareEntry(rel)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(target, path); err != nil {
+		return err
+	}
+	x.files = append(x.files, rel)
+	return nil
+}
+
+// writeHardlink links the entry to a previously extracted regular
+// file.
+func (x *archiveExtractor) writeHardlink(rel, target string) error {
+	elems, err := getArchiveEntryElems(target)
+	if err != nil {
+		return err
+	}
+	if len(elems) <= x.asset.stripComponents {
+		return fmt.Errorf("Hardlink %q points to %q, which was stripped", rel, target)
+	}
+	targetRel := filepath.Join(elems[x.asset.stripComponents:]...)
+	if err := checkNoSymlinks(x.root, targetRel); err != nil {
+		return fmt.Errorf("Hardlink %q: %v", rel, err)
+	}
+	targetPath := filepath.Join(x.root, targetRel)
+	if fi, err := os.Lstat(targetPath); err != nil || !fi.Mode().IsRegular() {
+		return fmt.Errorf("Hardlink %q points to %q, which is not an extracted file", rel, target)
+	}
+	path, err := x.prepareEntry(rel)
+	if err != nil {
+		return err
+	}
+	if err := os.Link(targetPath, path); err != nil {
+		return err
+	}
+	x.files = append(x.files, rel)
+	return nil
+}
+
+// getAssets returns the assets of the extracted files and the empty
+// extracted directories, sorted by path.
+func (x *archiveExtractor) getAssets() ([]string, error) {
+	paths := append([]string{}, x.files...)
+	for dir := range x.dirs {
+		entries, err := ioutil.ReadDir(filepath.Join(x.root, dir))
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			paths = append(paths, dir)
+		}
+	}
+	sort.Strings(paths)
+	assets := make([]string, 0, len(paths))
+	seen := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+		assets = append(assets, getAssetString(filepath.Join(x.asset.aci, path), filepath.Join(x.root, path)))
+	}
+	return assets, nil
+}
@@ -0,0 +1,341 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"archive/tar"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tarEntry is an entry of a test tar archive.
+type tarEntry struct {
+	name string
+	typ  byte
+	body string
+	link string
+}
+
+func writeTestTar(t *testing.T, path string, entries []tarEntry) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typ,
+			Linkname: e.link,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+		}
+		if e.typ == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if e.typ != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseArchiveAssets(t *testing.T) {
+	tests := []struct {
+		spec   string
+		format string
+		strip  int
+		fail   bool
+	}{
+		{spec: "/opt:/tmp/a.tar", format: "tar"},
+		{spec: "/opt:/tmp/a.tgz:strip-components=2", format: "tar.gz", strip: 2},
+		{spec: "/opt:/tmp/a.tar.xz:include=bin/:exclude=*.md", format: "tar.xz"},
+		{spec: "/opt:/tmp/a.zip", format: "zip"},
+		{spec: "/opt", fail: true},
+		{spec: "/opt:/tmp/a.rar", fail: true},
+		{spec: "/opt:/tmp/a.tar:strip-components=-1", fail: true},
+		{spec: "/opt:/tmp/a.tar:strip-components", fail: true},
+		{spec: "/opt:/tmp/a.tar:unknown=1", fail: true},
+		{spec: "/opt:/tmp/a.tar:exclude=[", fail: true},
+	}
+	for _, tt := range tests {
+		assets, err := parseArchiveAssets([]string{tt.spec})
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if assets[0].format != tt.format || assets[0].stripComponents != tt.strip {
+			t.Errorf("%q: got format %q and strip-components %d", tt.spec, assets[0].format, assets[0].stripComponents)
+		}
+	}
+}
+
+func TestGetArchiveEntryElems(t *testing.T) {
+	tests := []struct {
+		name  string
+		elems []string
+		fail  bool
+	}{
+		{name: "a/b/c", elems: []string{"a", "b", "c"}},
+		{name: "./a//b/", elems: []string{"a", "b"}},
+		{name: ".", elems: []string{}},
+		{name: "/etc/passwd", fail: true},
+		{name: "../x", fail: true},
+		{name: "a/../../x", fail: true},
+		{name: "a/..", fail: true},
+	}
+	for _, tt := range tests {
+		elems, err := getArchiveEntryElems(tt.name)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(elems, tt.elems) {
+			t.Errorf("%q: expected %v, got %v", tt.name, tt.elems, elems)
+		}
+	}
+}
+
+func TestArchiveExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		// OUTSIDE in the link targets is replaced with a
+		// directory next to the extraction directory
+		entries []tarEntry
+		options string
+		// assets are the expected ACI paths mapped to the
+		// extracted paths relative to the extraction directory
+		assets map[string]string
+		fail   bool
+	}{
+		{
+			name: "strip components, include and exclude",
+			entries: []tarEntry{
+				{name: "pkg-1.0/", typ: tar.TypeDir},
+				{name: "pkg-1.0/bin/tool", typ: tar.TypeReg, body: "tool"},
+				{name: "pkg-1.0/bin/tool.md", typ: tar.TypeReg, body: "doc"},
+				{name: "pkg-1.0/src/main.c", typ: tar.TypeReg, body: "src"},
+				{name: "pkg-1.0/bin/link", typ: tar.TypeSymlink, link: "/usr/lib/libfoo.so"},
+				{name: "pkg-1.0/bin/hard", typ: tar.TypeLink, link: "pkg-1.0/bin/tool"},
+			},
+			options: ":strip-components=1:include=bin/:exclude=*.md",
+			assets: map[string]string{
+				"/opt/pkg/bin/hard": "bin/hard",
+				"/opt/pkg/bin/link": "bin/link",
+				"/opt/pkg/bin/tool": "bin/tool",
+			},
+		},
+		{
+			name: "strip components leaving nothing",
+			entries: []tarEntry{
+				{name: "pkg/", typ: tar.TypeDir},
+				{name: "pkg/a", typ: tar.TypeReg, body: "a"},
+			},
+			options: ":strip-components=2",
+			assets:  map[string]string{},
+		},
+		{
+			name: "empty directories",
+			entries: []tarEntry{
+				{name: "var/empty/", typ: tar.TypeDir},
+			},
+			assets: map[string]string{"/opt/pkg/var/empty": "var/empty"},
+		},
+		{
+			name:    "absolute name",
+			entries: []tarEntry{{name: "/etc/passwd", typ: tar.TypeReg, body: "x"}},
+			fail:    true,
+		},
+		{
+			name:    "dot-dot name",
+			entries: []tarEntry{{name: "a/../../outside", typ: tar.TypeReg, body: "x"}},
+			fail:    true,
+		},
+		{
+			name: "write through a symlink",
+			entries: []tarEntry{
+				{name: "lib", typ: tar.TypeSymlink, link: "OUTSIDE"},
+				{name: "lib/evil", typ: tar.TypeReg, body: "x"},
+			},
+			fail: true,
+		},
+		{
+			name: "write through a symlinked directory",
+			entries: []tarEntry{
+				{name: "a/", typ: tar.TypeDir},
+				{name: "a/b", typ: tar.TypeSymlink, link: "OUTSIDE"},
+				{name: "a/b/c/evil", typ: tar.TypeReg, body: "x"},
+			},
+			fail: true,
+		},
+		{
+			name: "hardlink outside",
+			entries: []tarEntry{
+				{name: "evil", typ: tar.TypeLink, link: "../outside/file"},
+			},
+			fail: true,
+		},
+		{
+			name: "hardlink to an absolute path",
+			entries: []tarEntry{
+				{name: "evil", typ: tar.TypeLink, link: "/etc/passwd"},
+			},
+			fail: true,
+		},
+		{
+			name: "hardlink to a symlink",
+			entries: []tarEntry{
+				{name: "link", typ: tar.TypeSymlink, link: "OUTSIDE/file"},
+				{name: "evil", typ: tar.TypeLink, link: "link"},
+			},
+			fail: true,
+		},
+		{
+			name: "hardlink through a symlink",
+			entries: []tarEntry{
+				{name: "dir", typ: tar.TypeSymlink, link: "OUTSIDE"},
+				{name: "evil", typ: tar.TypeLink, link: "dir/file"},
+			},
+			fail: true,
+		},
+		{
+			name: "hardlink to a stripped entry",
+			entries: []tarEntry{
+				{name: "top", typ: tar.TypeReg, body: "x"},
+				{name: "pkg/evil", typ: tar.TypeLink, link: "top"},
+			},
+			options: ":strip-components=1",
+			fail:    true,
+		},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "goaci-archive-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		outside := filepath.Join(dir, "outside")
+		if err := os.MkdirAll(outside, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(outside, "file"), []byte("secret"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for i := range tt.entries {
+			if strings.HasPrefix(tt.entries[i].link, "OUTSIDE") {
+				tt.entries[i].link = outside + strings.TrimPrefix(tt.entries[i].link, "OUTSIDE")
+			}
+		}
+		archive := filepath.Join(dir, "test.tar")
+		writeTestTar(t, archive, tt.entries)
+		assets, err := parseArchiveAssets([]string{"/opt/pkg:" + archive + tt.options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := filepath.Join(dir, "extracted")
+		extracted, err := assets[0].extract(context.Background(), root)
+		if files := listFiles(t, outside); !reflect.DeepEqual(files, []string{"file"}) {
+			t.Errorf("%s: files written outside: %v", tt.name, files)
+		}
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		got := map[string]string{}
+		for _, asset := range extracted {
+			paths := filepath.SplitList(asset)
+			rel, err := filepath.Rel(root, paths[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[paths[0]] = rel
+		}
+		if !reflect.DeepEqual(got, tt.assets) {
+			t.Errorf("%s: expected assets %v, got %v", tt.name, tt.assets, got)
+		}
+	}
+}
+
+// TestPrepareAssetsSymlinkToHost checks that copying a symlink to a
+// host executable does not copy the libraries of the executable.
+func TestPrepareAssetsSymlinkToHost(t *testing.T) {
+	host, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		t.Skip("no /proc/self/exe: ", err)
+	}
+	dir, err := ioutil.TempDir("", "goaci-archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	rootfs := filepath.Join(dir, "rootfs")
+	for _, d := range []string{src, rootfs} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(host, filepath.Join(src, "tool")); err != nil {
+		t.Fatal(err)
+	}
+	if err := PrepareAssets([]string{"/opt/" + listSeparator() + src + "/"}, rootfs, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(rootfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"opt"}) {
+		t.Errorf("expected only /opt in rootfs, got %v", names)
+	}
+}
@@ -15,12 +15,11 @@
 package proj2aci
 
 import (
-	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 )
 
@@ -29,49 +28,320 @@ func GetAssetString(aciAsset, localAsset string) string {
 	return getAssetString(aciAsset, localAsset)
 }
 
+const (
+	// RelativeAssetsCwd resolves relative local asset paths
+	// against the current working directory.
+	RelativeAssetsCwd = "cwd"
+	// RelativeAssetsProject resolves relative local asset paths
+	// against the project checkout.
+	RelativeAssetsProject = "project"
+)
+
+// ValidateRelativeAssets checks if rule is a known rule for resolving
+// relative local asset paths. An empty rule means that relative local
+// paths are not allowed.
+func ValidateRelativeAssets(rule string) error {
+	switch rule {
+	case "", RelativeAssetsCwd, RelativeAssetsProject:
+		return nil
+	}
+	return fmt.Errorf("Unknown rule for relative assets %q, expected %s or %s", rule, RelativeAssetsCwd, RelativeAssetsProject)
+}
+
+// AssetsOptions keeps settings affecting how PrepareAssets copies the
+// assets and finds their dependencies.
+type AssetsOptions struct {
+	// Sysroot is a directory used as a root directory when
+	// looking for shared libraries. Empty means "/". It is useful
+	// when building an image for other architecture.
+	Sysroot string
+	// LibDirs are additional directories inside the sysroot to
+	// search for shared libraries, like LD_LIBRARY_PATH.
+	LibDirs []string
+	// InstallRoots are directories mirroring the ACI rootfs
+	// layout (like DESTDIR of "make install"). Shared libraries
+	// are looked for in them before the sysroot and get the path
+	// relative to the install root in the ACI rootfs.
+	InstallRoots []string
+	// Excludes are patterns of paths in the ACI rootfs which
+	// should not be copied, see pathFilter for the syntax.
+	Excludes []string
+	// LibRules are rules for copying files loaded at runtime by
+	// the libraries (see parseLibRules for the syntax). Nil means
+	// the default rules, see GetDefaultLibRules.
+	LibRules []string
+	// SymlinkStyle says how to rewrite the symlinks pointing
+	// into the copied local directories, SymlinkStyleRelative
+	// (the default if empty) or SymlinkStyleAbsolute.
+	SymlinkStyle string
+	// OnConflict says what to do when different files are copied
+	// to the same path in the ACI rootfs, ConflictError (the
+	// default if empty), ConflictFirst or ConflictLast.
+	OnConflict string
+	// ExplainFormat is a format in which the reasons for copying
+	// the assets are written to ExplainOutput (os.Stdout if nil),
+	// ExplainFormatTree or ExplainFormatDot. Empty means no
+	// explanation.
+	ExplainFormat string
+	ExplainOutput io.Writer
+	// Defines are additional placeholders (like "<NAME>") and
+	// their values. Unlike the placeholders passed to
+	// PrepareAssets, their values are not build directories.
+	Defines map[string]string
+	// PlaceholdersExpanded means that the caller has already
+	// expanded the placeholders in the assets, so PrepareAssets
+	// takes "<name>" in them literally.
+	PlaceholdersExpanded bool
+	// LocalBaseDir is a directory against which the relative
+	// local asset paths are resolved. Empty means that relative
+	// local paths are errors.
+	LocalBaseDir string
+	// ACIBaseDir is a directory in the ACI rootfs against which
+	// the relative ACI asset paths are resolved. Empty means that
+	// relative ACI paths are errors.
+	ACIBaseDir string
+	// Overlays are local directories mirroring the ACI rootfs
+	// layout, copied in order after the assets. Their files
+	// replace the files copied before, whatever the OnConflict
+	// policy is.
+	Overlays []string
+}
+
+// assetsPreparer holds the state of copying the assets to the ACI
+// rootfs directory.
+type assetsPreparer struct {
+	rootfs             string
+	placeholderMapping map[string]string
+	localBaseDir       string
+	aciBaseDir         string
+	resolver           *libResolver
+	filter             *pathFilter
+	libRules           []*libRule
+	// copiedFiles maps already copied local files to their copies
+	// in rootfs, so hardlinks can be preserved.
+	copiedFiles map[fileID]string
+	// mappings are the processed assets, used for rewriting the
+	// symlinks pointing to the local files.
+	mappings []assetPair
+	// symlinks are the paths of the copied symlinks in rootfs.
+	symlinks     []string
+	symlinkStyle string
+	// owners maps the paths of the copied files in rootfs to the
+	// local files they were copied from, so conflicts can be
+	// detected.
+	owners     map[string]string
+	onConflict string
+	// overlaying is true when copying the overlays.
+	overlaying bool
+	graph      *assetGraph
+}
+
 // PrepareAssets copies given assets to ACI rootfs directory. It also
 // tries to copy required shared libraries if an asset is a
-// dynamically linked executable or library. placeholderMapping maps
+// dynamically linked executable or library and the interpreter if an
+// asset is a script. placeholderMapping maps
 // placeholders (like "<INSTALLDIR>") to actual paths (usually
-// something inside temporary directory).
-func PrepareAssets(assets []string, rootfs string, placeholderMapping map[string]string) error {
-	newAssets := assets
+// something inside temporary directory). Unknown placeholders are
+// errors, "<ENV:VAR>" placeholders are replaced with the values of
+// the environment variables and "<<" is a literal "<". Only the given
+// assets are expanded, once, not the files found while copying them
+// (like the matches of globs or the shared libraries). options can
+// be nil.
+func PrepareAssets(assets []string, rootfs string, placeholderMapping map[string]string, options *AssetsOptions) error {
+	if options == nil {
+		options = &AssetsOptions{}
+	}
+	filter, err := newPathFilter(options.Excludes)
+	if err != nil {
+		return err
+	}
+	libRuleSpecs := options.LibRules
+	if libRuleSpecs == nil {
+		libRuleSpecs = defaultLibRules
+	}
+	libRules, err := parseLibRules(libRuleSpecs)
+	if err != nil {
+		return err
+	}
+	if err := ValidateSymlinkStyle(options.SymlinkStyle); err != nil {
+		return err
+	}
+	if err := ValidateConflictPolicy(options.OnConflict); err != nil {
+		return err
+	}
+	if err := ValidateExplainFormat(options.ExplainFormat); err != nil {
+		return err
+	}
+	for _, dir := range []string{options.LocalBaseDir, options.ACIBaseDir} {
+		if dir != "" && !filepath.IsAbs(dir) {
+			return fmt.Errorf("Base directory for relative assets %q has to be an absolute path", dir)
+		}
+	}
+	preparer := &assetsPreparer{
+		rootfs:             rootfs,
+		placeholderMapping: placeholderMapping,
+		localBaseDir:       options.LocalBaseDir,
+		aciBaseDir:         options.ACIBaseDir,
+		resolver:           newLibResolver(options.Sysroot, options.InstallRoots, options.LibDirs),
+		filter:             filter,
+		libRules:           libRules,
+		copiedFiles:        make(map[fileID]string),
+		symlinkStyle:       options.SymlinkStyle,
+		owners:             make(map[string]string),
+		onConflict:         options.OnConflict,
+		graph:              newAssetGraph(),
+	}
+	if !options.PlaceholdersExpanded {
+		placeholders := mergePlaceholders(placeholderMapping, options.Defines)
+		if assets, err = expandAllAssetPlaceholders(assets, placeholders); err != nil {
+			return err
+		}
+	}
+	if err := preparer.prepare(assets, assetReason{kind: reasonExplicit}); err != nil {
+		return err
+	}
+	if err := preparer.prepareOverlays(options.Overlays); err != nil {
+		return err
+	}
+	if err := preparer.fixSymlinks(); err != nil {
+		return err
+	}
+	if options.ExplainFormat != "" {
+		output := options.ExplainOutput
+		if output == nil {
+			output = os.Stdout
+		}
+		return preparer.graph.write(output, options.ExplainFormat)
+	}
+	return nil
+}
+
+func (p *assetsPreparer) prepare(assets []string, reason assetReason) error {
+	newAssets := getPendingAssets(assets, reason)
 	processedAssets := make(map[string]struct{})
 	for len(newAssets) > 0 {
 		assetsToProcess := newAssets
 		newAssets = nil
-		for _, asset := range assetsToProcess {
-			splitAsset := filepath.SplitList(asset)
+		for _, pending := range assetsToProcess {
+			splitAsset := filepath.SplitList(pending.asset)
 			if len(splitAsset) != 2 {
-				return fmt.Errorf("Malformed asset option: '%v' - expected two absolute paths separated with %v", asset, listSeparator())
+				return fmt.Errorf("Malformed asset option: '%v' - expected two absolute paths separated with %v", pending.asset, listSeparator())
 			}
-			evalSrc, srcErr := evalPath(splitAsset[0])
-			if srcErr != nil {
-				return fmt.Errorf("Could not evaluate symlinks in source asset %q: %v", evalSrc, srcErr)
-			}
-			evalDest, destErr := evalPath(splitAsset[1])
-			asset = getAssetString(evalSrc, evalDest)
-			Debug("Processing asset:", asset)
-			if _, ok := processedAssets[asset]; ok {
-				Debug("  skipped")
-				continue
+			ACIAsset := resolveAssetPath(p.aciBaseDir, splitAsset[0])
+			localAsset := resolveAssetPath(p.localBaseDir, splitAsset[1])
+			expandedAssets, err := expandAsset(ACIAsset, localAsset)
+			if err != nil {
+				return err
 			}
-			additionalAssets, err := processAsset(asset, rootfs, placeholderMapping)
-			if destErr != nil {
-				evalDest, destErr = evalPath(evalDest)
-				if destErr != nil {
-					return fmt.Errorf("Could not evaluate symlinks in destination asset %q, even after it was copied to: %v", evalDest, destErr)
+			for _, expanded := range expandedAssets {
+				evalLocal, err := evalPath(expanded.local)
+				if err != nil {
+					return fmt.Errorf("Could not evaluate symlinks in local asset %q: %v", expanded.local, err)
+				}
+				asset := getAssetString(expanded.aci, evalLocal)
+				Debug("Processing asset:", asset, " (", pending.reason, ")")
+				p.graph.add(expanded.aci, pending.reason)
+				if _, ok := processedAssets[asset]; ok {
+					Debug("  skipped")
+					continue
+				}
+				additionalAssets, err := p.processAsset(expanded.aci, evalLocal)
+				if err != nil {
+					return err
 				}
-				asset = getAssetString(evalSrc, evalDest)
+				processedAssets[asset] = struct{}{}
+				newAssets = append(newAssets, additionalAssets...)
 			}
+		}
+	}
+	return nil
+}
+
+// prepareOverlays copies the contents of the overlay directories to
+// the ACI rootfs, one by one.
+func (p *assetsPreparer) prepareOverlays(overlays []string) error {
+	p.overlaying = true
+	defer func() { p.overlaying = false }()
+	for _, dir := range overlays {
+		asset := getAssetString("/", strings.TrimSuffix(dir, "/")+"/")
+		if err := p.prepare([]string{asset}, assetReason{kind: reasonOverlay}); err != nil {
+			return fmt.Errorf("Failed to copy overlay %q: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// resolveAssetPath resolves a relative asset path against baseDir,
+// keeping the trailing slash. Absolute paths and all paths when
+// baseDir is empty are returned unchanged.
+func resolveAssetPath(baseDir, path string) string {
+	if baseDir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	resolved := filepath.Join(baseDir, path)
+	if strings.HasSuffix(path, "/") && !strings.HasSuffix(resolved, "/") {
+		resolved += "/"
+	}
+	return resolved
+}
+
+// assetPair is an asset with placeholders already replaced.
+type assetPair struct {
+	aci   string
+	local string
+}
+
+// expandAsset turns an asset into a list of assets to copy. If
+// localAsset contains wildcards, then each match is copied into
+// ACIAsset directory, keeping the part of its path matched by the
+// wildcards. Otherwise the trailing slashes work like in rsync - a
+// local directory with a trailing slash means copying its contents
+// instead of the directory itself and an ACI path with a trailing
+// slash means copying the local asset into that directory.
+func expandAsset(ACIAsset, localAsset string) ([]assetPair, error) {
+	if hasGlobMeta(localAsset) {
+		matches, err := expandGlob(localAsset)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid local asset pattern %q: %v", localAsset, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No files match local asset pattern %q", localAsset)
+		}
+		base, _ := splitGlob(localAsset)
+		assets := make([]assetPair, 0, len(matches))
+		for _, match := range matches {
+			rel, err := filepath.Rel(base, match)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			processedAssets[asset] = struct{}{}
-			newAssets = append(newAssets, additionalAssets...)
+			assets = append(assets, assetPair{
+				aci:   filepath.Join(ACIAsset, rel),
+				local: match,
+			})
 		}
+		return assets, nil
 	}
-	return nil
+	contentsOnly := strings.HasSuffix(localAsset, "/") && localAsset != "/"
+	intoDir := strings.HasSuffix(ACIAsset, "/")
+	if !contentsOnly {
+		aciPath := ACIAsset
+		if intoDir {
+			aciPath = filepath.Join(ACIAsset, filepath.Base(localAsset))
+		}
+		return []assetPair{{aci: filepath.Clean(aciPath), local: filepath.Clean(localAsset)}}, nil
+	}
+	entries, err := ioutil.ReadDir(localAsset)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read contents of local asset directory %q: %v", localAsset, err)
+	}
+	assets := make([]assetPair, 0, len(entries))
+	for _, entry := range entries {
+		assets = append(assets, assetPair{
+			aci:   filepath.Join(ACIAsset, entry.Name()),
+			local: filepath.Join(localAsset, entry.Name()),
+		})
+	}
+	return assets, nil
 }
 
 func evalPath(path string) (string, error) {
@@ -84,55 +354,53 @@ func evalPath(path string) (string, error) {
 	return filepath.Join(realSymlinkDir, symlinkBase), nil
 }
 
-// processAsset validates an asset, replaces placeholders with real
-// paths and does the copying. It may return additional assets to be
-// processed when asset is an executable or a library.
-func processAsset(asset, rootfs string, placeholderMapping map[string]string) ([]string, error) {
-	splitAsset := filepath.SplitList(asset)
-	if len(splitAsset) != 2 {
-		return nil, fmt.Errorf("Malformed asset option: '%v' - expected two absolute paths separated with %v", asset, listSeparator())
-	}
-	ACIAsset := replacePlaceholders(splitAsset[0], placeholderMapping)
-	localAsset := replacePlaceholders(splitAsset[1], placeholderMapping)
+// processAsset validates an asset and does the copying. It may return
+// additional assets to be processed when asset is an executable, a
+// library or a script.
+func (p *assetsPreparer) processAsset(ACIAsset, localAsset string) ([]pendingAsset, error) {
+	asset := getAssetString(ACIAsset, localAsset)
 	if err := validateAsset(ACIAsset, localAsset); err != nil {
 		return nil, err
 	}
-	ACIAssetSubPath := filepath.Join(rootfs, filepath.Dir(ACIAsset))
+	if fi, err := os.Stat(localAsset); err == nil && p.filter.excludes(ACIAsset, fi.IsDir()) {
+		Debug("Excluding asset: ", asset)
+		return nil, nil
+	}
+	ACIAssetSubPath := filepath.Join(p.rootfs, filepath.Dir(ACIAsset))
 	err := os.MkdirAll(ACIAssetSubPath, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create directory tree for asset '%v': %v", asset, err)
 	}
-	err = copyTree(localAsset, filepath.Join(rootfs, ACIAsset))
+	err = p.copyTree(localAsset, ACIAsset)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to copy assets for %q: %v", asset, err)
 	}
-	additionalAssets, err := getSoLibs(localAsset)
+	p.mappings = append(p.mappings, assetPair{aci: ACIAsset, local: localAsset})
+	companionAssets, err := p.getCompanionAssets(ACIAsset, localAsset)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get companion assets for %q: %v", localAsset, err)
+	}
+	// A symlink is copied without its target, so the target's
+	// libraries and interpreter are not needed. Following it could
+	// also pull in host files, like when an extracted archive has a
+	// symlink to a host library.
+	if fi, err := os.Lstat(localAsset); err != nil || isSymlink(fi.Mode()) {
+		return companionAssets, err
+	}
+	additionalAssets, err := p.resolver.getSoLibs(ACIAsset, localAsset)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get dependent assets for %q: %v", localAsset, err)
 	}
-	// HACK! if we are copying libc then try to copy libnss_* libs
-	// (glibc name service switch libs used in networking)
-	if matched, err := filepath.Match("libc.*", filepath.Base(localAsset)); err == nil && matched {
-		toGlob := filepath.Join(filepath.Dir(localAsset), "libnss_*")
-		if matches, err := filepath.Glob(toGlob); err == nil && len(matches) > 0 {
-			matchesAsAssets := make([]string, 0, len(matches))
-			for _, f := range matches {
-				matchesAsAssets = append(matchesAsAssets, getAssetString(f, f))
-			}
-			additionalAssets = append(additionalAssets, matchesAsAssets...)
-		}
+	interpreterAssets, err := p.getInterpreterAssets(ACIAsset, localAsset)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get interpreter assets for %q: %v", localAsset, err)
 	}
-	return additionalAssets, nil
-}
-
-func replacePlaceholders(path string, placeholderMapping map[string]string) string {
-	Debug("Processing path: ", path)
-	newPath := path
-	for placeholder, replacement := range placeholderMapping {
-		newPath = strings.Replace(newPath, placeholder, replacement, -1)
-	}
-	Debug("Processed path: ", newPath)
-	return newPath
+	additionalAssets = append(additionalAssets, companionAssets...)
+	reason := assetReason{
+		kind:   reasonScriptInterpreter,
+		parent: ACIAsset,
+	}
+	return append(additionalAssets, getPendingAssets(interpreterAssets, reason)...), nil
 }
 
 func validateAsset(ACIAsset, localAsset string) error {
@@ -140,7 +408,7 @@ func validateAsset(ACIAsset, localAsset string) error {
 		return fmt.Errorf("Wrong ACI asset: '%v' - ACI asset has to be absolute path", ACIAsset)
 	}
 	if !filepath.IsAbs(localAsset) {
-		return fmt.Errorf("Wrong local asset: '%v' - local asset has to be absolute path", localAsset)
+		return fmt.Errorf("Wrong local asset: '%v' - local asset has to be absolute path unless relative assets are enabled", localAsset)
 	}
 	fi, err := os.Lstat(localAsset)
 	if err != nil {
@@ -153,36 +421,78 @@ func validateAsset(ACIAsset, localAsset string) error {
 	return fmt.Errorf("Can't handle local asset %v - not a file, not a dir, not a symlink", fi.Name())
 }
 
-func copyTree(src, dest string) error {
+// copyTree copies src to ACI rootfs as ACIPath, skipping the excluded
+// paths.
+func (p *assetsPreparer) copyTree(src, ACIPath string) error {
 	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		rootLess := path[len(src):]
-		target := filepath.Join(dest, rootLess)
+		ACITarget := filepath.Join(ACIPath, rootLess)
+		target := filepath.Join(p.rootfs, ACITarget)
 		mode := info.Mode()
+		if path != src && p.filter.excludes(ACITarget, mode.IsDir()) {
+			Debug("Excluding ", path)
+			if mode.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path != src && !mode.IsDir() {
+			p.graph.add(ACITarget, assetReason{kind: reasonContents, parent: ACIPath})
+		}
 		switch {
 		case mode.IsDir():
+			// merge with already existing directories
+			if fi, err := os.Stat(target); err == nil && fi.IsDir() {
+				return nil
+			}
 			err := os.Mkdir(target, mode.Perm())
 			if err != nil {
 				return err
 			}
 		case mode.IsRegular():
-			if err := copyRegularFile(path, target); err != nil {
+			if copy, err := p.claimPath(ACITarget, path); err != nil || !copy {
+				return err
+			}
+			if err := p.copyRegularFile(path, target, info); err != nil {
 				return err
 			}
 		case isSymlink(mode):
+			if copy, err := p.claimPath(ACITarget, path); err != nil || !copy {
+				return err
+			}
 			if err := copySymlink(path, target); err != nil {
 				return err
 			}
+			p.symlinks = append(p.symlinks, ACITarget)
 		default:
-			return fmt.Errorf("Unsupported node %q in assets, only regular files, directories and symlinks are supported.", path, mode.String())
+			return fmt.Errorf("Unsupported node %q (%s) in assets, only regular files, directories and symlinks are supported. Use special nodes for devices and FIFOs.", path, mode.String())
 		}
 		return nil
 	})
 }
 
-func copyRegularFile(src, dest string) error {
+// copyRegularFile copies src to dest. If src was already copied
+// (possibly as some other hardlink to it), dest becomes a hardlink to
+// the earlier copy.
+func (p *assetsPreparer) copyRegularFile(src, dest string, info os.FileInfo) error {
+	// dest could be a hardlink to some other file, so make sure
+	// we are not overwriting its contents
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	id, hasID := getFileID(info)
+	if hasID {
+		if copied, ok := p.copiedFiles[id]; ok {
+			if _, err := os.Lstat(copied); err == nil {
+				Debug("hardlinking ", dest, " to ", copied)
+				return os.Link(copied, dest)
+			}
+		}
+		p.copiedFiles[id] = dest
+	}
 	srcFile, err := os.Open(src)
 	if err != nil {
 		return err
@@ -211,83 +521,15 @@ func copySymlink(src, dest string) error {
 	if err != nil {
 		return err
 	}
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	if err := os.Symlink(symTarget, dest); err != nil {
 		return err
 	}
 	return nil
 }
 
-// getSoLibs tries to run ldd on given path and to process its output
-// to get a list of shared libraries to copy. This list is returned as
-// an array of assets.
-//
-// man ldd says that running ldd on untrusted executables is dangerous
-// (it might run an executable to get the libraries), so possibly this
-// should be replaced with objdump. Problem with objdump is that it
-// just gives library names, while ldd gives absolute paths to those
-// libraries - to use objdump we need to know the $libdir.
-func getSoLibs(path string) ([]string, error) {
-	assets := []string{}
-	buf := new(bytes.Buffer)
-	args := []string{
-		"ldd",
-		path,
-	}
-	if err := RunCmdFull("", args, nil, "", buf, nil); err != nil {
-		if _, ok := err.(CmdFailedError); !ok {
-			return nil, err
-		}
-	} else {
-		re := regexp.MustCompile(`(?m)^\t(?:\S+\s+=>\s+)?(\/\S+)\s+\([0-9a-fA-Fx]+\)$`)
-		for _, matches := range re.FindAllStringSubmatch(string(buf.Bytes()), -1) {
-			lib := matches[1]
-			if lib == "" {
-				continue
-			}
-			symlinkedAssets, err := getSymlinkedAssets(lib)
-			if err != nil {
-				return nil, err
-			}
-			assets = append(assets, symlinkedAssets...)
-		}
-	}
-	return assets, nil
-}
-
-// getSymlinkedAssets returns an array of many assets if given path is
-// a symlink - useful for getting shared libraries, which are often
-// surrounded with a bunch of symlinks.
-func getSymlinkedAssets(path string) ([]string, error) {
-	assets := []string{}
-	maxLevels := 100
-	levels := maxLevels
-	for {
-		if levels < 1 {
-			return nil, fmt.Errorf("Too many levels of symlinks (>$d)", maxLevels)
-		}
-		fi, err := os.Lstat(path)
-		if err != nil {
-			return nil, err
-		}
-		asset := getAssetString(path, path)
-		assets = append(assets, asset)
-		if !isSymlink(fi.Mode()) {
-			break
-		}
-		symTarget, err := os.Readlink(path)
-		if err != nil {
-			return nil, err
-		}
-		if filepath.IsAbs(symTarget) {
-			path = symTarget
-		} else {
-			path = filepath.Join(filepath.Dir(path), symTarget)
-		}
-		levels--
-	}
-	return assets, nil
-}
-
 func getAssetString(aciAsset, localAsset string) string {
 	return fmt.Sprintf("%s%s%s", aciAsset, listSeparator(), localAsset)
 }
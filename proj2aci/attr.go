@@ -0,0 +1,252 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// capabilityPAXRecord is a PAX record used for storing file
+	// capabilities in tar archives.
+	capabilityPAXRecord = "SCHILY.xattr.security.capability"
+	// vfsCapRevision2 and vfsCapFlagsEffective are values used
+	// in the header of the security.capability extended
+	// attribute (see linux/capability.h).
+	vfsCapRevision2      = 0x02000000
+	vfsCapFlagsEffective = 0x000001
+)
+
+// capabilities maps names of the Linux capabilities to their numbers.
+var capabilities = map[string]uint{
+	"cap_chown":              0,
+	"cap_dac_override":       1,
+	"cap_dac_read_search":    2,
+	"cap_fowner":             3,
+	"cap_fsetid":             4,
+	"cap_kill":               5,
+	"cap_setgid":             6,
+	"cap_setuid":             7,
+	"cap_setpcap":            8,
+	"cap_linux_immutable":    9,
+	"cap_net_bind_service":   10,
+	"cap_net_broadcast":      11,
+	"cap_net_admin":          12,
+	"cap_net_raw":            13,
+	"cap_ipc_lock":           14,
+	"cap_ipc_owner":          15,
+	"cap_sys_module":         16,
+	"cap_sys_rawio":          17,
+	"cap_sys_chroot":         18,
+	"cap_sys_ptrace":         19,
+	"cap_sys_pacct":          20,
+	"cap_sys_admin":          21,
+	"cap_sys_boot":           22,
+	"cap_sys_nice":           23,
+	"cap_sys_resource":       24,
+	"cap_sys_time":           25,
+	"cap_sys_tty_config":     26,
+	"cap_mknod":              27,
+	"cap_lease":              28,
+	"cap_audit_write":        29,
+	"cap_audit_control":      30,
+	"cap_setfcap":            31,
+	"cap_mac_override":       32,
+	"cap_mac_admin":          33,
+	"cap_syslog":             34,
+	"cap_wake_alarm":         35,
+	"cap_block_suspend":      36,
+	"cap_audit_read":         37,
+	"cap_perfmon":            38,
+	"cap_bpf":                39,
+	"cap_checkpoint_restore": 40,
+}
+
+// assetAttr describes overrides of attributes of files in the ACI
+// rootfs matching the pattern. Nil fields are not overridden.
+type assetAttr struct {
+	pattern string
+	mode    *int64
+	uid     *int
+	gid     *int
+	caps    []byte
+}
+
+// GetAssetAttrString returns a properly formatted asset attributes
+// string.
+func GetAssetAttrString(pattern string, attrs ...string) string {
+	return strings.Join(append([]string{pattern}, attrs...), listSeparator())
+}
+
+// parseAssetAttrs parses asset attribute specifications. Each
+// specification is an absolute path (or a pattern, see matchGlob) in
+// the ACI rootfs followed by attributes, all separated with the path
+// list separator, like "/etc/app/**:mode=0600:uid=1000:gid=1000" or
+// "/bin/ping:caps=cap_net_raw+ep". The mode is not applied to the
+// directories matched through a "**" element, so "/etc/app/**" with
+// mode=0600 keeps the subdirectories of /etc/app accessible.
+func parseAssetAttrs(specs []string) ([]*assetAttr, error) {
+	attrs := make([]*assetAttr, 0, len(specs))
+	for _, spec := range specs {
+		attr, err := parseAssetAttr(spec)
+		if err != nil {
+			return nil, fmt.Errorf("Malformed asset attributes %q: %v", spec, err)
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+func parseAssetAttr(spec string) (*assetAttr, error) {
+	fields := filepath.SplitList(spec)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("expected a path and at least one attribute separated with %v", listSeparator())
+	}
+	attr := &assetAttr{
+		pattern: fields[0],
+	}
+	if !filepath.IsAbs(attr.pattern) {
+		return nil, fmt.Errorf("path has to be absolute")
+	}
+	if err := validateGlob(attr.pattern); err != nil {
+		return nil, err
+	}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected key=value, got %q", field)
+		}
+		switch key, value := kv[0], kv[1]; key {
+		case "mode":
+			mode, err := strconv.ParseInt(value, 8, 64)
+			if err != nil || mode < 0 || mode > 07777 {
+				return nil, fmt.Errorf("invalid mode %q, expected an octal number not greater than 07777", value)
+			}
+			attr.mode = &mode
+		case "uid", "gid":
+			id, err := strconv.Atoi(value)
+			if err != nil || id < 0 {
+				return nil, fmt.Errorf("invalid %s %q, expected a nonnegative number", key, value)
+			}
+			if key == "uid" {
+				attr.uid = &id
+			} else {
+				attr.gid = &id
+			}
+		case "caps":
+			caps, err := encodeCapabilities(value)
+			if err != nil {
+				return nil, err
+			}
+			attr.caps = caps
+		default:
+			return nil, fmt.Errorf("unknown attribute %q, expected one of mode, uid, gid or caps", key)
+		}
+	}
+	return attr, nil
+}
+
+// encodeCapabilities turns a textual representation of file
+// capabilities (like "cap_net_raw,cap_net_admin+ep") into a value of
+// the security.capability extended attribute.
+func encodeCapabilities(text string) ([]byte, error) {
+	plus := strings.LastIndex(text, "+")
+	if plus < 0 {
+		return nil, fmt.Errorf("invalid capabilities %q, expected a list of capabilities followed by + and flags (e, p or i)", text)
+	}
+	var caps uint64
+	for _, name := range strings.Split(text[:plus], ",") {
+		num, ok := capabilities[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+		caps |= 1 << num
+	}
+	var effective, permitted, inheritable bool
+	for _, flag := range text[plus+1:] {
+		switch flag {
+		case 'e':
+			effective = true
+		case 'p':
+			permitted = true
+		case 'i':
+			inheritable = true
+		default:
+			return nil, fmt.Errorf("invalid capability flag %q, expected e, p or i", flag)
+		}
+	}
+	magic := uint32(vfsCapRevision2)
+	if effective {
+		magic |= vfsCapFlagsEffective
+	}
+	// magic, then permitted and inheritable sets, the lower 32
+	// bits first
+	data := []uint32{magic, 0, 0, 0, 0}
+	if permitted {
+		data[1] = uint32(caps)
+		data[3] = uint32(caps >> 32)
+	}
+	if inheritable {
+		data[2] = uint32(caps)
+		data[4] = uint32(caps >> 32)
+	}
+	buf := make([]byte, 4*len(data))
+	for i, v := range data {
+		binary.LittleEndian.PutUint32(buf[4*i:], v)
+	}
+	return buf, nil
+}
+
+// matches checks if the attributes apply to a path in ACI rootfs.
+func (attr *assetAttr) matches(aciPath string) bool {
+	matched, _ := matchGlob(attr.pattern, aciPath)
+	return matched
+}
+
+// isRecursive checks if the pattern has a "**" element.
+func (attr *assetAttr) isRecursive() bool {
+	for _, elem := range splitPath(attr.pattern) {
+		if elem == recursiveWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// apply overrides the attributes in the tar header.
+func (attr *assetAttr) apply(hdr *tar.Header) {
+	if attr.mode != nil && !(hdr.Typeflag == tar.TypeDir && attr.isRecursive()) {
+		hdr.Mode = *attr.mode
+	}
+	if attr.uid != nil {
+		hdr.Uid = *attr.uid
+		hdr.Uname = ""
+	}
+	if attr.gid != nil {
+		hdr.Gid = *attr.gid
+		hdr.Gname = ""
+	}
+	if attr.caps != nil {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords[capabilityPAXRecord] = string(attr.caps)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseAssetAttr(t *testing.T) {
+	tests := []struct {
+		spec string
+		mode int64
+		uid  int
+		gid  int
+		// caps are the permitted capabilities, if any
+		caps uint32
+		fail bool
+	}{
+		{spec: "/etc/app/**:mode=0600:uid=1000:gid=1001", mode: 0600, uid: 1000, gid: 1001},
+		{spec: "/bin/app:mode=4755", mode: 04755, uid: -1, gid: -1},
+		{spec: "/bin/ping:caps=cap_net_raw,CAP_NET_ADMIN+ep", mode: -1, uid: -1, gid: -1, caps: 1<<13 | 1<<12},
+		{spec: "/bin/app", fail: true},
+		{spec: "bin/app:mode=0755", fail: true},
+		{spec: "/bin/[:mode=0755", fail: true},
+		{spec: "/bin/app:mode", fail: true},
+		{spec: "/bin/app:mode=0855", fail: true},
+		{spec: "/bin/app:mode=17777", fail: true},
+		{spec: "/bin/app:mode=-1", fail: true},
+		{spec: "/bin/app:mode=rwx", fail: true},
+		{spec: "/bin/app:uid=-1", fail: true},
+		{spec: "/bin/app:uid=root", fail: true},
+		{spec: "/bin/app:gid=1.5", fail: true},
+		{spec: "/bin/app:gid=", fail: true},
+		{spec: "/bin/app:caps=cap_net_raw", fail: true},
+		{spec: "/bin/app:caps=cap_bogus+ep", fail: true},
+		{spec: "/bin/app:caps=cap_net_raw+x", fail: true},
+		{spec: "/bin/app:caps=+ep", fail: true},
+		{spec: "/bin/app:owner=root", fail: true},
+	}
+	for _, tt := range tests {
+		attr, err := parseAssetAttr(tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.spec, err)
+			continue
+		}
+		hdr := &tar.Header{Mode: -1, Uid: -1, Gid: -1}
+		attr.apply(hdr)
+		if hdr.Mode != tt.mode || hdr.Uid != tt.uid || hdr.Gid != tt.gid {
+			t.Errorf("%q: expected mode %o, uid %d and gid %d, got %o, %d and %d", tt.spec, tt.mode, tt.uid, tt.gid, hdr.Mode, hdr.Uid, hdr.Gid)
+		}
+		caps, ok := hdr.PAXRecords[capabilityPAXRecord]
+		if tt.caps == 0 {
+			if ok {
+				t.Errorf("%q: unexpected capabilities", tt.spec)
+			}
+			continue
+		}
+		if len(caps) != 20 {
+			t.Errorf("%q: expected 20 bytes of capabilities, got %d", tt.spec, len(caps))
+			continue
+		}
+		magic := binary.LittleEndian.Uint32([]byte(caps[0:4]))
+		permitted := binary.LittleEndian.Uint32([]byte(caps[4:8]))
+		if magic != vfsCapRevision2|vfsCapFlagsEffective || permitted != tt.caps {
+			t.Errorf("%q: expected magic %#x and permitted %#x, got %#x and %#x", tt.spec, vfsCapRevision2|vfsCapFlagsEffective, tt.caps, magic, permitted)
+		}
+	}
+}
+
+func TestAssetAttrApplyDirectories(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		typeflag byte
+		mode     int64
+	}{
+		{pattern: "/etc/app/**", path: "/etc/app/conf.d", typeflag: tar.TypeDir, mode: 0755},
+		{pattern: "/etc/app/**", path: "/etc/app", typeflag: tar.TypeDir, mode: 0755},
+		{pattern: "/etc/app/**", path: "/etc/app/conf.d/a.conf", typeflag: tar.TypeReg, mode: 0600},
+		{pattern: "/etc/app", path: "/etc/app", typeflag: tar.TypeDir, mode: 0600},
+		{pattern: "/etc/app/*", path: "/etc/app/conf.d", typeflag: tar.TypeDir, mode: 0600},
+	}
+	for _, tt := range tests {
+		attr, err := parseAssetAttr(tt.pattern + ":mode=0600")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !attr.matches(tt.path) {
+			t.Errorf("%q: expected %q to match", tt.pattern, tt.path)
+			continue
+		}
+		hdr := &tar.Header{Typeflag: tt.typeflag, Mode: 0755}
+		attr.apply(hdr)
+		if hdr.Mode != tt.mode {
+			t.Errorf("%q on %q: expected mode %o, got %o", tt.pattern, tt.path, tt.mode, hdr.Mode)
+		}
+	}
+}
@@ -17,11 +17,18 @@ package proj2aci
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/appc/spec/aci"
 	"github.com/appc/spec/schema"
@@ -34,13 +41,52 @@ import (
 // via GetCommonConfiguration function and modify it before running
 // Builder.Run().
 type CommonConfiguration struct {
-	Exec        []string
-	UseBinary   string
-	Assets      []string
-	KeepTmpDir  bool
-	TmpDir      string
-	ReuseTmpDir string
-	Project     string
+	Exec                  []string
+	UseBinary             string
+	Assets                []string
+	KeepTmpDir            bool
+	TmpDir                string
+	ReuseTmpDir           string
+	Project               string
+	Sysroot               string
+	LibDirs               []string
+	AssetExcludes         []string
+	AssetExcludePresets   []string
+	AssetAttrs            []string
+	NoDedupe              bool
+	SpecialNodes          []string
+	LibRules              []string
+	LibRulesFiles         []string
+	NoDefaultLibRules     bool
+	Bundles               []string
+	User                  string
+	Group                 string
+	SupplementaryGroups   []string
+	WritableDirs          []string
+	Strip                 bool
+	Objcopy               string
+	DebugArchive          string
+	DebugACI              string
+	SymlinkStyle          string
+	AllowDanglingSymlinks bool
+	OnConflict            string
+	ExplainAssets         string
+	ExplainAssetsFile     string
+	AssetCacheDir         string
+	ArchiveAssets         []string
+	Defines               []string
+	RelativeAssets        string
+	TemplateAssets        []string
+	BuildInfoFile         string
+	Flags                 []string
+	Overlays              []string
+	Hooks                 []string
+	Timeout               time.Duration
+	PhaseTimeout          time.Duration
+	LogFormat             string
+	Quiet                 bool
+	Verbose               bool
+	LogDir                string
 }
 
 // CommonPaths keeps some paths common for all builders. Implementers
@@ -60,12 +106,14 @@ type BuilderCustomizations interface {
 	GetCommonPaths() *CommonPaths
 	SetupPaths() error
 	GetDirectoriesToMake() []string
-	PrepareProject() error
+	PrepareProject(ctx context.Context) error
 	GetPlaceholderMapping() map[string]string
 	GetAssets(aciBinDir string) ([]string, error)
+	GetInstallRoots() []string
 	GetImageName() (*types.ACIdentifier, error)
 	GetBinaryName() (string, error)
 	GetRepoPath() (string, error)
+	GetToolVersions(ctx context.Context) (map[string]string, error)
 	GetImageFileName() (string, error)
 }
 
@@ -73,6 +121,22 @@ type Builder struct {
 	manifest  *schema.ImageManifest
 	aciBinDir string
 	custom    BuilderCustomizations
+	// vcsLabel caches the result of getVCSLabel, it is valid if
+	// vcsLabelDone is true.
+	vcsLabel     *types.Label
+	vcsLabelDone bool
+	// logger is the logger given with SetLogger, if nil, Run
+	// creates one from the configuration.
+	logger Logger
+	// log adds the build fields to the messages logged during
+	// Run.
+	log *builderLogger
+	// phase is the current phase of the build.
+	phase string
+	// accounts are the user and the groups the app is run as,
+	// resolved against the accounts in ACI rootfs after copying
+	// the assets.
+	accounts *accounts
 }
 
 func NewBuilder(custom BuilderCustomizations) *Builder {
@@ -87,23 +151,57 @@ func (cmd *Builder) Name() string {
 	return cmd.custom.Name()
 }
 
-func (cmd *Builder) Run() error {
-	Info("Validating builder configuration")
-	if err := cmd.validateConfiguration(); err != nil {
+// SetLogger makes Run log to l instead of a logger created from the
+// log format and the quiet and verbose settings.
+func (cmd *Builder) SetLogger(l Logger) {
+	cmd.logger = l
+}
+
+// Run builds the ACI. When ctx is done, the running commands are
+// killed and the temporary directory is removed (unless it should be
+// kept).
+//
+// The build is logged with the builder's logger, which stays the
+// logger of Info, Warn and Debug after Run returns. The messages get
+// the builder, project and phase fields.
+func (cmd *Builder) Run(ctx context.Context) error {
+	logger, err := cmd.getLogger()
+	if err != nil {
 		return err
 	}
+	cmd.log = newBuilderLogger(logger, LogFields{
+		"builder": cmd.custom.Name(),
+		"project": cmd.custom.GetCommonConfiguration().Project,
+	})
+	SetLogger(cmd.log)
+	defer SetLogger(logger)
 
-	Info("Setting up paths")
-	if err := cmd.setupPaths(); err != nil {
+	cmd.startPhase("validate")
+	Info("Validating builder configuration")
+	if err := cmd.validateConfiguration(); err != nil {
 		return err
 	}
 
 	config := cmd.custom.GetCommonConfiguration()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	cmd.startPhase("setup")
+	Info("Setting up paths")
+	err = cmd.setupPaths()
 	paths := cmd.custom.GetCommonPaths()
-	if config.KeepTmpDir {
-		Info(fmt.Sprintf("Preserving temporary directory %q", paths.TmpDir))
-	} else {
-		defer os.RemoveAll(paths.TmpDir)
+	if paths.TmpDir != "" {
+		if config.KeepTmpDir {
+			Info(fmt.Sprintf("Preserving temporary directory %q", paths.TmpDir))
+		} else {
+			defer cmd.removeTmpDir()
+		}
+	}
+	if err != nil {
+		return err
 	}
 
 	if config.ReuseTmpDir != "" {
@@ -123,38 +221,152 @@ func (cmd *Builder) Run() error {
 			return err
 		}
 
+		if err := cmd.runHooks(ctx, HookPrePrepare); err != nil {
+			return err
+		}
+
+		cmd.startPhase("prepare")
 		Info("Preparing a project")
-		if err := cmd.prepareProject(); err != nil {
+		if err := cmd.runPhase(ctx, "preparing a project", cmd.prepareProject); err != nil {
+			return err
+		}
+
+		if err := cmd.runHooks(ctx, HookPostPrepare); err != nil {
 			return err
 		}
 	}
 
+	cmd.startPhase("assets")
 	Info("Copying assets to ACI directory")
-	if err := cmd.copyAssets(); err != nil {
+	if err := cmd.runPhase(ctx, "copying assets", cmd.copyAssets); err != nil {
+		return err
+	}
+
+	if config.BuildInfoFile != "" {
+		cmd.startPhase("build-info")
+		Info("Writing build info")
+		if err := cmd.runPhase(ctx, "writing build info", cmd.writeBuildInfo); err != nil {
+			return err
+		}
+	}
+
+	if config.Strip {
+		cmd.startPhase("strip")
+		Info("Stripping binaries")
+		if err := cmd.runPhase(ctx, "stripping binaries", cmd.stripBinaries); err != nil {
+			return err
+		}
+	}
+
+	if err := cmd.runHooks(ctx, HookPreManifest); err != nil {
 		return err
 	}
 
+	cmd.startPhase("manifest")
 	Info("Preparing manifest")
 	if err := cmd.prepareManifest(); err != nil {
 		return err
 	}
 
+	if err := cmd.runHooks(ctx, HookPreWrite); err != nil {
+		return err
+	}
+
+	cmd.startPhase("write")
 	Info("Writing ACI")
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if name, err := cmd.writeACI(); err != nil {
 		return err
 	} else {
 		Info(fmt.Sprintf("Done, wrote %q", name))
 	}
+
+	if config.DebugArchive != "" || config.DebugACI != "" {
+		cmd.startPhase("debug-info")
+		Info("Writing debug info")
+		if err := cmd.writeDebugInfo(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// getLogger returns the logger given with SetLogger or a new one
+// created from the configuration.
+func (cmd *Builder) getLogger() (Logger, error) {
+	if cmd.logger != nil {
+		return cmd.logger, nil
+	}
+	config := cmd.custom.GetCommonConfiguration()
+	return NewLogger(config.LogFormat, getLogLevel(config.Quiet, config.Verbose), os.Stdout, os.Stderr)
+}
+
+// startPhase sets the phase field of the messages logged from now on
+// and the name of the phase's log file.
+func (cmd *Builder) startPhase(phase string) {
+	cmd.phase = phase
+	cmd.log.setField("phase", phase)
+}
+
+// runPhase runs a phase of the build, limited by the phase timeout if
+// it is set. If the phase fails after ctx is done, the error says if
+// it timed out or was interrupted. If the log directory is set, the
+// output of the commands run in the phase goes to a file named after
+// the current phase there.
+func (cmd *Builder) runPhase(ctx context.Context, name string, phase func(context.Context) error) error {
+	config := cmd.custom.GetCommonConfiguration()
+	if config.PhaseTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.PhaseTimeout)
+		defer cancel()
+	}
+	if config.LogDir != "" {
+		if err := os.MkdirAll(config.LogDir, 0755); err != nil {
+			return err
+		}
+		path := filepath.Join(config.LogDir, cmd.phase+".log")
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		Debug(fmt.Sprintf("Writing output of commands to %q", path))
+		ctx = WithCmdOutput(ctx, f, f)
+	}
+	err := ctx.Err()
+	if err == nil {
+		err = phase(ctx)
+	}
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("Timed out %s: %v", name, err)
+	case ctx.Err() == context.Canceled:
+		return fmt.Errorf("Interrupted %s: %v", name, err)
+	}
+	return err
+}
+
+// removeTmpDir removes the temporary directory. A failure is reported,
+// so the directory can be removed by hand.
+func (cmd *Builder) removeTmpDir() {
+	paths := cmd.custom.GetCommonPaths()
+	Debug("removing ", paths.TmpDir)
+	if err := os.RemoveAll(paths.TmpDir); err != nil {
+		Warn(fmt.Sprintf("Failed to remove temporary directory %q: %v", paths.TmpDir, err))
+	}
+}
+
 func (cmd *Builder) validateConfiguration() error {
 	config := cmd.custom.GetCommonConfiguration()
 	if config == nil {
 		panic("common configuration is nil")
 	}
 	if config.Project == "" {
-		fmt.Errorf("Got no project to build")
+		return fmt.Errorf("Got no project to build")
 	}
 
 	if config.TmpDir != "" && config.ReuseTmpDir != "" && config.TmpDir != config.ReuseTmpDir {
@@ -163,6 +375,92 @@ func (cmd *Builder) validateConfiguration() error {
 	if !DirExists(config.ReuseTmpDir) {
 		return fmt.Errorf("Invalid tmp dir to reuse")
 	}
+	if !DirExists(config.Sysroot) {
+		return fmt.Errorf("Invalid sysroot")
+	}
+	for _, preset := range config.AssetExcludePresets {
+		if _, err := GetAssetExcludePreset(preset); err != nil {
+			return err
+		}
+	}
+	if _, err := parseAssetAttrs(config.AssetAttrs); err != nil {
+		return err
+	}
+	if _, err := parseSpecialNodes(config.SpecialNodes); err != nil {
+		return err
+	}
+	if _, err := cmd.getLibRules(); err != nil {
+		return err
+	}
+	if _, err := parseArchiveAssets(config.ArchiveAssets); err != nil {
+		return err
+	}
+	if err := cmd.validatePlaceholders(); err != nil {
+		return err
+	}
+	if assets, err := cmd.getExpandedAssets(); err != nil {
+		return err
+	} else if err := validateRemoteAssets(assets); err != nil {
+		return err
+	}
+	if err := ValidateRelativeAssets(config.RelativeAssets); err != nil {
+		return err
+	}
+	if _, err := parseTemplateAssets(config.TemplateAssets); err != nil {
+		return err
+	}
+	if config.BuildInfoFile != "" && !filepath.IsAbs(config.BuildInfoFile) {
+		return fmt.Errorf("Build info file %q has to be an absolute path in ACI rootfs", config.BuildInfoFile)
+	}
+	if _, err := getBuildTimestamp(); err != nil {
+		return err
+	}
+	if _, err := parseHooks(config.Hooks); err != nil {
+		return err
+	}
+	if config.Timeout < 0 || config.PhaseTimeout < 0 {
+		return fmt.Errorf("Timeouts can't be negative")
+	}
+	if err := ValidateSymlinkStyle(config.SymlinkStyle); err != nil {
+		return err
+	}
+	if err := ValidateConflictPolicy(config.OnConflict); err != nil {
+		return err
+	}
+	if err := ValidateExplainFormat(config.ExplainAssets); err != nil {
+		return err
+	}
+	if config.ExplainAssets != "" && config.ExplainAssetsFile == "" && config.LogFormat == LogFormatJSON {
+		return fmt.Errorf("Asset explanation would be mixed with the JSON log messages on stdout, write it to a file instead")
+	}
+	if err := ValidateLogFormat(config.LogFormat); err != nil {
+		return err
+	}
+	if config.Quiet && config.Verbose {
+		return fmt.Errorf("Can't be quiet and verbose at the same time")
+	}
+	if err := validateBundles(config.Bundles); err != nil {
+		return err
+	}
+	if _, err := cmd.getAccounts(); err != nil {
+		return err
+	}
+	if err := validateWritableDirs(config.WritableDirs); err != nil {
+		return err
+	}
+	if (config.DebugArchive != "" || config.DebugACI != "") && !config.Strip {
+		return fmt.Errorf("Debug info can be written only when stripping binaries")
+	}
+	if config.Strip {
+		if _, err := exec.LookPath(cmd.getObjcopy()); err != nil {
+			return fmt.Errorf("Can't strip binaries: %v", err)
+		}
+	}
+	for _, dir := range config.LibDirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("Library directory %q has to be an absolute path", dir)
+		}
+	}
 
 	return cmd.custom.ValidateConfiguration()
 }
@@ -233,11 +531,11 @@ func tmpDirList(path string) ([]string, error) {
 	return list, nil
 }
 
-func (cmd *Builder) prepareProject() error {
-	return cmd.custom.PrepareProject()
+func (cmd *Builder) prepareProject(ctx context.Context) error {
+	return cmd.custom.PrepareProject(ctx)
 }
 
-func (cmd *Builder) copyAssets() error {
+func (cmd *Builder) copyAssets(ctx context.Context) error {
 	paths := cmd.custom.GetCommonPaths()
 	config := cmd.custom.GetCommonConfiguration()
 	mapping := cmd.custom.GetPlaceholderMapping()
@@ -245,10 +543,527 @@ func (cmd *Builder) copyAssets() error {
 	if err != nil {
 		return err
 	}
-	assets := append(config.Assets, customAssets...)
-	if err := PrepareAssets(assets, paths.RootFS, mapping); err != nil {
+	bundleAssets, err := cmd.getBundleAssets()
+	if err != nil {
+		return err
+	}
+	fetcher := &remoteFetcher{
+		client:      http.DefaultClient,
+		cacheDir:    cmd.getAssetCacheDir(),
+		downloadDir: filepath.Join(paths.TmpDir, "remote-assets"),
+	}
+	expandedAssets, err := cmd.getExpandedAssets()
+	if err != nil {
+		return err
+	}
+	configAssets, err := fetcher.getAssets(ctx, expandedAssets)
+	if err != nil {
+		return err
+	}
+	archiveAssets, err := cmd.getArchiveAssets(ctx)
+	if err != nil {
+		return err
+	}
+	templateAssets, err := cmd.getTemplateAssets()
+	if err != nil {
+		return err
+	}
+	assets := append(configAssets, archiveAssets...)
+	assets = append(assets, templateAssets...)
+	assets = append(assets, customAssets...)
+	assets = append(assets, bundleAssets...)
+	excludes := append([]string{}, config.AssetExcludes...)
+	for _, preset := range config.AssetExcludePresets {
+		patterns, err := GetAssetExcludePreset(preset)
+		if err != nil {
+			return err
+		}
+		excludes = append(excludes, patterns...)
+	}
+	libRules, err := cmd.getLibRules()
+	if err != nil {
+		return err
+	}
+	baseDir, err := cmd.getLocalBaseDir()
+	if err != nil {
+		return err
+	}
+	overlays, err := cmd.getOverlays()
+	if err != nil {
+		return err
+	}
+	var explainOutput io.Writer
+	if config.ExplainAssets != "" && config.ExplainAssetsFile != "" {
+		f, err := os.Create(config.ExplainAssetsFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		explainOutput = f
+	}
+	options := &AssetsOptions{
+		Sysroot:       config.Sysroot,
+		LibDirs:       config.LibDirs,
+		InstallRoots:  cmd.custom.GetInstallRoots(),
+		Excludes:      excludes,
+		LibRules:      libRules,
+		SymlinkStyle:  config.SymlinkStyle,
+		OnConflict:    config.OnConflict,
+		ExplainFormat: config.ExplainAssets,
+		ExplainOutput: explainOutput,
+		LocalBaseDir:  baseDir,
+		ACIBaseDir:    cmd.aciBinDir,
+		Overlays:      overlays,
+		// The configured assets are expanded already and the
+		// other ones are produced by the builder, their names
+		// are taken literally.
+		PlaceholdersExpanded: true,
+	}
+	if err := PrepareAssets(assets, paths.RootFS, mapping, options); err != nil {
+		return err
+	}
+	existing, err := readExistingAccounts(paths.RootFS)
+	if err != nil {
+		return err
+	}
+	accts, err := parseAccounts(config.User, config.Group, config.SupplementaryGroups, existing)
+	if err != nil {
+		return err
+	}
+	if err := prepareAccounts(paths.RootFS, accts); err != nil {
+		return fmt.Errorf("Failed to add accounts: %v", err)
+	}
+	cmd.accounts = accts
+	return nil
+}
+
+// getArchiveAssets extracts the archive assets into the temporary
+// directory and returns the assets of the extracted files.
+func (cmd *Builder) getArchiveAssets(ctx context.Context) ([]string, error) {
+	paths := cmd.custom.GetCommonPaths()
+	config := cmd.custom.GetCommonConfiguration()
+	mapping, err := cmd.getPlaceholders()
+	if err != nil {
+		return nil, err
+	}
+	archives, err := parseArchiveAssets(config.ArchiveAssets)
+	if err != nil {
+		return nil, err
+	}
+	assets := []string{}
+	baseDir, err := cmd.getLocalBaseDir()
+	if err != nil {
+		return nil, err
+	}
+	for i, archive := range archives {
+		if archive.archive, err = expandPlaceholders(archive.archive, mapping); err != nil {
+			return nil, err
+		}
+		if archive.aci, err = expandPlaceholders(archive.aci, mapping); err != nil {
+			return nil, err
+		}
+		archive.archive = resolveAssetPath(baseDir, archive.archive)
+		if !filepath.IsAbs(archive.archive) {
+			return nil, fmt.Errorf("Archive %q has to be an absolute path, unless relative assets are enabled", archive.archive)
+		}
+		Info(fmt.Sprintf("Extracting %s", archive.archive))
+		dir := filepath.Join(paths.TmpDir, "archive-assets", strconv.Itoa(i))
+		extracted, err := archive.extract(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, extracted...)
+	}
+	return assets, nil
+}
+
+// runHooks runs the hooks of the phase. The hooks of the prepare
+// phases are not run when reusing the temporary directory, because
+// the project is not prepared then.
+func (cmd *Builder) runHooks(ctx context.Context, phase string) error {
+	config := cmd.custom.GetCommonConfiguration()
+	hooks, err := parseHooks(config.Hooks)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	placeholders, err := cmd.getPlaceholders()
+	if err != nil {
+		return err
+	}
+	env := getHookEnv(phase, cmd.custom.Name(), cmd.custom.GetCommonPaths(), placeholders)
+	cmd.startPhase(phase)
+	return cmd.runPhase(ctx, fmt.Sprintf("running %s hooks", phase), func(ctx context.Context) error {
+		return runHooks(ctx, hooks, phase, env)
+	})
+}
+
+// getOverlays returns the overlay directories with the placeholders
+// expanded and the relative paths resolved.
+func (cmd *Builder) getOverlays() ([]string, error) {
+	config := cmd.custom.GetCommonConfiguration()
+	mapping, err := cmd.getPlaceholders()
+	if err != nil {
+		return nil, err
+	}
+	baseDir, err := cmd.getLocalBaseDir()
+	if err != nil {
+		return nil, err
+	}
+	overlays := make([]string, 0, len(config.Overlays))
+	for _, overlay := range config.Overlays {
+		dir, err := expandPlaceholders(overlay, mapping)
+		if err != nil {
+			return nil, err
+		}
+		dir = resolveAssetPath(baseDir, dir)
+		if !DirExists(dir) || !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("Overlay %q is not an absolute path to a directory, relative paths need --relative-assets", dir)
+		}
+		overlays = append(overlays, dir)
+	}
+	return overlays, nil
+}
+
+// getTemplateAssets renders the template assets into the temporary
+// directory and returns the assets of the rendered files.
+func (cmd *Builder) getTemplateAssets() ([]string, error) {
+	paths := cmd.custom.GetCommonPaths()
+	config := cmd.custom.GetCommonConfiguration()
+	templates, err := parseTemplateAssets(config.TemplateAssets)
+	if err != nil || len(templates) == 0 {
+		return nil, err
+	}
+	mapping, err := cmd.getPlaceholders()
+	if err != nil {
+		return nil, err
+	}
+	baseDir, err := cmd.getLocalBaseDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := cmd.getTemplateData()
+	if err != nil {
+		return nil, err
+	}
+	assets := make([]string, 0, len(templates))
+	for i, tmpl := range templates {
+		local, err := expandPlaceholders(tmpl.local, mapping)
+		if err != nil {
+			return nil, err
+		}
+		local = resolveAssetPath(baseDir, local)
+		if !filepath.IsAbs(local) {
+			return nil, fmt.Errorf("Template %q has to be an absolute path, unless relative assets are enabled", local)
+		}
+		aci, err := expandPlaceholders(tmpl.aci, mapping)
+		if err != nil {
+			return nil, err
+		}
+		rendered := filepath.Join(paths.TmpDir, "template-assets", strconv.Itoa(i), filepath.Base(local))
+		Debug("rendering template ", local, " to ", rendered)
+		if err := renderTemplate(local, rendered, data); err != nil {
+			return nil, fmt.Errorf("Failed to render template %q: %v", local, err)
+		}
+		assets = append(assets, getAssetString(aci, rendered))
+	}
+	return assets, nil
+}
+
+// getTemplateData returns the data available in the template assets.
+func (cmd *Builder) getTemplateData() (*TemplateData, error) {
+	config := cmd.custom.GetCommonConfiguration()
+	labels, err := cmd.getLabels()
+	if err != nil {
+		return nil, err
+	}
+	vcsLabel, err := cmd.getVCSLabel()
+	if err != nil {
+		return nil, err
+	}
+	defines, err := cmd.getDefines()
+	if err != nil {
+		return nil, err
+	}
+	data := &TemplateData{
+		Project: config.Project,
+		Builder: cmd.custom.Name(),
+		Labels:  make(map[string]string, len(labels)),
+		Defines: make(map[string]string, len(defines)),
+	}
+	if vcsLabel != nil {
+		data.Revision = vcsLabel.Value
+	}
+	for _, label := range labels {
+		data.Labels[label.Name.String()] = label.Value
+	}
+	for placeholder, value := range defines {
+		data.Defines[strings.Trim(placeholder, "<>")] = value
+	}
+	data.Version = data.Defines["VERSION"]
+	return data, nil
+}
+
+// writeBuildInfo writes the build info file to the ACI rootfs.
+func (cmd *Builder) writeBuildInfo(ctx context.Context) error {
+	paths := cmd.custom.GetCommonPaths()
+	config := cmd.custom.GetCommonConfiguration()
+	vcsLabel, err := cmd.getVCSLabel()
+	if err != nil {
+		return err
+	}
+	tools, err := cmd.custom.GetToolVersions(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get tool versions: %v", err)
+	}
+	timestamp, err := getBuildTimestamp()
+	if err != nil {
+		return err
+	}
+	info := &BuildInfo{
+		Project:   config.Project,
+		Builder:   cmd.custom.Name(),
+		Tools:     tools,
+		Flags:     config.Flags,
+		Timestamp: timestamp.Format(time.RFC3339),
+	}
+	if vcsLabel != nil {
+		info.VCS = vcsLabel.Name.String()
+		info.Revision = vcsLabel.Value
+	}
+	if info.Flags == nil {
+		info.Flags = []string{}
+	}
+	if err := writeBuildInfo(paths.RootFS, config.BuildInfoFile, info); err != nil {
+		return fmt.Errorf("Failed to write build info: %v", err)
+	}
+	return nil
+}
+
+// getLocalBaseDir returns the directory against which the relative
+// local asset paths are resolved, an empty string if relative local
+// paths are not allowed.
+func (cmd *Builder) getLocalBaseDir() (string, error) {
+	config := cmd.custom.GetCommonConfiguration()
+	switch config.RelativeAssets {
+	case RelativeAssetsCwd:
+		return os.Getwd()
+	case RelativeAssetsProject:
+		return cmd.custom.GetRepoPath()
+	}
+	return "", nil
+}
+
+// getDefines returns the user-defined placeholders. Their values can
+// use the builder placeholders and the environment placeholders.
+func (cmd *Builder) getDefines() (map[string]string, error) {
+	config := cmd.custom.GetCommonConfiguration()
+	mapping := cmd.custom.GetPlaceholderMapping()
+	defines, err := parseDefines(config.Defines, mapping)
+	if err != nil {
+		return nil, err
+	}
+	for placeholder, value := range defines {
+		if defines[placeholder], err = expandPlaceholders(value, mapping); err != nil {
+			return nil, fmt.Errorf("Failed to expand define %s: %v", placeholder, err)
+		}
+	}
+	return defines, nil
+}
+
+// getPlaceholders returns the builder placeholders together with the
+// user-defined ones.
+func (cmd *Builder) getPlaceholders() (map[string]string, error) {
+	defines, err := cmd.getDefines()
+	if err != nil {
+		return nil, err
+	}
+	return mergePlaceholders(cmd.custom.GetPlaceholderMapping(), defines), nil
+}
+
+// getExpandedAssets returns the assets with the placeholders
+// expanded, so the URLs of the remote assets can use them too.
+func (cmd *Builder) getExpandedAssets() ([]string, error) {
+	config := cmd.custom.GetCommonConfiguration()
+	mapping, err := cmd.getPlaceholders()
+	if err != nil {
+		return nil, err
+	}
+	return expandAllAssetPlaceholders(config.Assets, mapping)
+}
+
+// validatePlaceholders checks if all the placeholders used in the
+// assets and the exec arguments are known and the environment
+// variables they use are set.
+func (cmd *Builder) validatePlaceholders() error {
+	config := cmd.custom.GetCommonConfiguration()
+	mapping, err := cmd.getPlaceholders()
+	if err != nil {
 		return err
 	}
+	lists := [][]string{config.Exec, config.Assets, config.ArchiveAssets, config.TemplateAssets, config.Overlays}
+	for _, list := range lists {
+		if _, err := expandAllPlaceholders(list, mapping); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAssetCacheDir returns the directory for caching the remote
+// assets, by default it is in the user's cache directory. An empty
+// string means no caching.
+func (cmd *Builder) getAssetCacheDir() string {
+	config := cmd.custom.GetCommonConfiguration()
+	if config.AssetCacheDir != "" {
+		return config.AssetCacheDir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		Debug("not caching remote assets: ", err)
+		return ""
+	}
+	return filepath.Join(dir, "goaci", "assets")
+}
+
+// getAccounts returns the user and the groups the app is run as. The
+// ids of the accounts given only by name are known after copying the
+// assets, before that they do not take the accounts in ACI rootfs
+// into account.
+func (cmd *Builder) getAccounts() (*accounts, error) {
+	if cmd.accounts != nil {
+		return cmd.accounts, nil
+	}
+	config := cmd.custom.GetCommonConfiguration()
+	return parseAccounts(config.User, config.Group, config.SupplementaryGroups, nil)
+}
+
+// getAssetAttrs returns the attributes making the writable
+// directories owned by the app's user, followed by the ones given by
+// the user, so the latter take precedence.
+func (cmd *Builder) getAssetAttrs() ([]*assetAttr, error) {
+	config := cmd.custom.GetCommonConfiguration()
+	paths := cmd.custom.GetCommonPaths()
+	accts, err := cmd.getAccounts()
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := prepareWritableDirs(paths.RootFS, config.WritableDirs, accts)
+	if err != nil {
+		return nil, err
+	}
+	userAttrs, err := parseAssetAttrs(config.AssetAttrs)
+	if err != nil {
+		return nil, err
+	}
+	return append(attrs, userAttrs...), nil
+}
+
+// getBundleAssets returns the assets of the bundles requested by the
+// user. Generated bundle files are kept in the temporary directory.
+func (cmd *Builder) getBundleAssets() ([]string, error) {
+	config := cmd.custom.GetCommonConfiguration()
+	if len(config.Bundles) == 0 {
+		return nil, nil
+	}
+	paths := cmd.custom.GetCommonPaths()
+	genDir := filepath.Join(paths.TmpDir, "bundles")
+	if err := os.RemoveAll(genDir); err != nil {
+		return nil, err
+	}
+	sysroot := config.Sysroot
+	if sysroot == "" {
+		sysroot = "/"
+	}
+	ctx := bundleContext{
+		sysroot: sysroot,
+		genDir:  genDir,
+	}
+	return getBundleAssets(config.Bundles, ctx)
+}
+
+// getLibRules returns the default library rules (unless disabled),
+// followed by the rules from the rule files and the ones given
+// directly.
+func (cmd *Builder) getLibRules() ([]string, error) {
+	config := cmd.custom.GetCommonConfiguration()
+	rules := []string{}
+	if !config.NoDefaultLibRules {
+		rules = append(rules, GetDefaultLibRules()...)
+	}
+	for _, path := range config.LibRulesFiles {
+		fileRules, err := ReadLibRulesFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read library rules file: %v", err)
+		}
+		rules = append(rules, fileRules...)
+	}
+	rules = append(rules, config.LibRules...)
+	if _, err := parseLibRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// getObjcopy returns the objcopy binary used for stripping.
+func (cmd *Builder) getObjcopy() string {
+	config := cmd.custom.GetCommonConfiguration()
+	if config.Objcopy != "" {
+		return config.Objcopy
+	}
+	return "objcopy"
+}
+
+// getDebugRootfs returns a directory where the debug info of stripped
+// binaries is kept, it is empty if the debug info is not needed.
+func (cmd *Builder) getDebugRootfs() string {
+	config := cmd.custom.GetCommonConfiguration()
+	if config.DebugArchive == "" && config.DebugACI == "" {
+		return ""
+	}
+	paths := cmd.custom.GetCommonPaths()
+	return filepath.Join(paths.TmpDir, "debug", "rootfs")
+}
+
+func (cmd *Builder) stripBinaries(ctx context.Context) error {
+	paths := cmd.custom.GetCommonPaths()
+	if err := os.RemoveAll(filepath.Join(paths.TmpDir, "debug")); err != nil {
+		return err
+	}
+	debugRootfs := cmd.getDebugRootfs()
+	if debugRootfs != "" {
+		if err := os.MkdirAll(debugRootfs, 0755); err != nil {
+			return err
+		}
+	}
+	return stripRootfs(ctx, cmd.getObjcopy(), paths.RootFS, debugRootfs)
+}
+
+// writeDebugInfo writes the debug info of stripped binaries to a
+// separate archive or an ACI named after the app's ACI with a -debug
+// suffix.
+func (cmd *Builder) writeDebugInfo() error {
+	config := cmd.custom.GetCommonConfiguration()
+	debugRootfs := cmd.getDebugRootfs()
+	if config.DebugArchive != "" {
+		if err := writeDebugArchive(debugRootfs, config.DebugArchive); err != nil {
+			return fmt.Errorf("Failed to write debug archive: %v", err)
+		}
+		Info(fmt.Sprintf("Wrote debug archive %q", config.DebugArchive))
+	}
+	if config.DebugACI != "" {
+		name, err := types.NewACIdentifier(cmd.manifest.Name.String() + "-debug")
+		if err != nil {
+			return err
+		}
+		manifest := schema.BlankImageManifest()
+		manifest.Name = *name
+		manifest.Labels = cmd.manifest.Labels
+		headerWalker := getTarHeaderWalker(nil)
+		if _, err := writeACIFile(config.DebugACI, manifest, filepath.Dir(debugRootfs), headerWalker, nil); err != nil {
+			return fmt.Errorf("Failed to write debug ACI: %v", err)
+		}
+		Info(fmt.Sprintf("Wrote debug ACI %q", config.DebugACI))
+	}
 	return nil
 }
 
@@ -280,11 +1095,25 @@ func (cmd *Builder) getApp() (*types.App, error) {
 	}
 	exec := []string{filepath.Join(cmd.aciBinDir, binaryName)}
 	config := cmd.custom.GetCommonConfiguration()
+	mapping, err := cmd.getPlaceholders()
+	if err != nil {
+		return nil, err
+	}
+	args, err := expandAllPlaceholders(config.Exec, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	accts, err := cmd.getAccounts()
+	if err != nil {
+		return nil, err
+	}
 
 	return &types.App{
-		Exec:  append(exec, config.Exec...),
-		User:  "0",
-		Group: "0",
+		Exec:              append(exec, args...),
+		User:              strconv.Itoa(accts.user.id),
+		Group:             strconv.Itoa(accts.group.id),
+		SupplementaryGIDs: accts.getSupplementaryGIDs(),
 	}, nil
 }
 
@@ -325,6 +1154,19 @@ func newLabel(name, value string) (*types.Label, error) {
 }
 
 func (cmd *Builder) getVCSLabel() (*types.Label, error) {
+	if cmd.vcsLabelDone {
+		return cmd.vcsLabel, nil
+	}
+	label, err := cmd.findVCSLabel()
+	if err != nil {
+		return nil, err
+	}
+	cmd.vcsLabel = label
+	cmd.vcsLabelDone = true
+	return label, nil
+}
+
+func (cmd *Builder) findVCSLabel() (*types.Label, error) {
 	repoPath, err := cmd.custom.GetRepoPath()
 	if err != nil {
 		return nil, err
@@ -334,6 +1176,10 @@ func (cmd *Builder) getVCSLabel() (*types.Label, error) {
 	}
 	name, value, err := GetVCSInfo(repoPath)
 	if err != nil {
+		if _, ok := err.(NoVCSError); ok {
+			Warn(fmt.Sprintf("Not adding a VCS label: %v", err))
+			return nil, nil
+		}
 		return nil, fmt.Errorf("Failed to get VCS info: %v", err)
 	}
 	acname, err := types.NewACIdentifier(name)
@@ -347,11 +1193,71 @@ func (cmd *Builder) getVCSLabel() (*types.Label, error) {
 }
 
 func (cmd *Builder) writeACI() (string, error) {
-	mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	config := cmd.custom.GetCommonConfiguration()
+	attrs, err := cmd.getAssetAttrs()
+	if err != nil {
+		return "", err
+	}
+	nodes, err := parseSpecialNodes(config.SpecialNodes)
+	if err != nil {
+		return "", err
+	}
+	paths := cmd.custom.GetCommonPaths()
+	if err := prepareSpecialNodes(nodes, paths.RootFS); err != nil {
+		return "", err
+	}
+	// the asset attributes given by the user take precedence
+	attrs = append(getDirAttrs(nodes), attrs...)
+	if err := cmd.checkDanglingSymlinks(nodes); err != nil {
+		return "", err
+	}
+	if !config.NoDedupe {
+		if err := dedupeRootfs(paths.RootFS, attrs); err != nil {
+			return "", fmt.Errorf("Failed to deduplicate files: %v", err)
+		}
+	}
 	filename, err := cmd.custom.GetImageFileName()
 	if err != nil {
 		return "", err
 	}
+	extra := []*tar.Header{}
+	for _, node := range nodes {
+		if hdr := node.getTarHeader(); hdr != nil {
+			extra = append(extra, hdr)
+		}
+	}
+	return writeACIFile(filename, cmd.manifest, paths.AciDir, getTarHeaderWalker(attrs), extra)
+}
+
+// checkDanglingSymlinks makes sure that the symlinks in the ACI rootfs
+// point to existing files, the special nodes are taken into account.
+func (cmd *Builder) checkDanglingSymlinks(nodes []*specialNode) error {
+	config := cmd.custom.GetCommonConfiguration()
+	paths := cmd.custom.GetCommonPaths()
+	nodePaths := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		nodePaths = append(nodePaths, node.path)
+	}
+	dangling, err := findDanglingSymlinks(paths.RootFS, nodePaths)
+	if err != nil {
+		return err
+	}
+	if len(dangling) == 0 {
+		return nil
+	}
+	if !config.AllowDanglingSymlinks {
+		return fmt.Errorf("Dangling symlinks in ACI rootfs: %s", strings.Join(dangling, ", "))
+	}
+	for _, link := range dangling {
+		Warn(fmt.Sprintf("Dangling symlink in ACI rootfs: %s", link))
+	}
+	return nil
+}
+
+// writeACIFile writes an ACI with the given manifest and the contents
+// of aciDir, followed by extra entries.
+func writeACIFile(filename string, manifest *schema.ImageManifest, aciDir string, headerWalker aci.TarHeaderWalkFunc, extra []*tar.Header) (string, error) {
+	mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
 	of, err := os.OpenFile(filename, mode, 0644)
 	if err != nil {
 		return "", fmt.Errorf("Error opening output file: %v", err)
@@ -364,16 +1270,39 @@ func (cmd *Builder) writeACI() (string, error) {
 	tr := tar.NewWriter(gw)
 	defer tr.Close()
 
-	// FIXME: the files in the tar archive are added with the
-	// wrong uid/gid. The uid/gid of the aci builder leaks in the
-	// tar archive. See: https://github.com/appc/goaci/issues/16
-	iw := aci.NewImageWriter(*cmd.manifest, tr)
-	paths := cmd.custom.GetCommonPaths()
-	if err := filepath.Walk(paths.AciDir, aci.BuildWalker(paths.AciDir, iw, nil)); err != nil {
+	iw := aci.NewImageWriter(*manifest, tr)
+	if err := filepath.Walk(aciDir, aci.BuildWalker(aciDir, iw, headerWalker)); err != nil {
 		return "", err
 	}
+	for _, hdr := range extra {
+		headerWalker(hdr)
+		if err := iw.AddFile(hdr, nil); err != nil {
+			return "", err
+		}
+	}
 	if err := iw.Close(); err != nil {
 		return "", err
 	}
 	return of.Name(), nil
 }
+
+// getTarHeaderWalker returns a function fixing up the tar headers of
+// the files in the ACI. The files are owned by root unless the asset
+// attributes say otherwise, so the uid/gid of the user running the
+// build does not leak into the archive.
+func getTarHeaderWalker(attrs []*assetAttr) aci.TarHeaderWalkFunc {
+	return func(hdr *tar.Header) bool {
+		hdr.Uid = 0
+		hdr.Gid = 0
+		hdr.Uname = ""
+		hdr.Gname = ""
+		if aciPath, ok := getSubPath("rootfs", hdr.Name); ok {
+			for _, attr := range attrs {
+				if attr.matches(aciPath) {
+					attr.apply(hdr)
+				}
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuildInfo describes how an ACI was built, it is written as JSON to
+// the build info file in the ACI rootfs.
+type BuildInfo struct {
+	Project  string `json:"project"`
+	Builder  string `json:"builder"`
+	VCS      string `json:"vcs,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	// Tools maps the names of the tools used for building the
+	// project to their versions.
+	Tools map[string]string `json:"tools"`
+	// Flags are the names of the command line flags goaci was
+	// run with, without their values.
+	Flags []string `json:"flags"`
+	// Timestamp is the build time in RFC 3339 format, taken from
+	// SOURCE_DATE_EPOCH if set, so reproducible builds produce
+	// the same file.
+	Timestamp string `json:"timestamp"`
+}
+
+// getBuildTimestamp returns the build time, which is the value of
+// SOURCE_DATE_EPOCH (seconds since the Unix epoch) if set or the
+// current time.
+func getBuildTimestamp() (time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Now().UTC(), nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid SOURCE_DATE_EPOCH %q, expected a number of seconds", epoch)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// getToolVersion runs the tool with args and returns the first line
+// of its output.
+func getToolVersion(ctx context.Context, prog string, args ...string) (string, error) {
+	buffer := new(bytes.Buffer)
+	if err := RunCmdFull(ctx, prog, args, nil, "", buffer, ioutil.Discard); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.SplitN(buffer.String(), "\n", 2)[0]), nil
+}
+
+// writeBuildInfo writes the build info as JSON to the ACI path in
+// rootfs, replacing the file copied there, if any.
+func writeBuildInfo(rootfs, ACIPath string, info *BuildInfo) error {
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(rootfs, ACIPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,303 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bundleContext keeps information needed by bundles to find or
+// generate their files.
+type bundleContext struct {
+	// sysroot is a directory where the bundle files are looked
+	// for.
+	sysroot string
+	// sourceDir is a directory given by the user to take the
+	// files from instead of looking for them in sysroot, may be
+	// empty.
+	sourceDir string
+	// genDir is a directory where generated files can be
+	// written.
+	genDir string
+}
+
+// bundle is a set of files commonly needed by apps at runtime.
+type bundle struct {
+	description string
+	getAssets   func(ctx *bundleContext) ([]string, error)
+}
+
+var bundles = map[string]bundle{
+	"ca-certs": {
+		description: "CA certificates bundle in /etc/ssl/certs/ca-certificates.crt",
+		getAssets:   getCACertsAssets,
+	},
+	"tzdata": {
+		description: "time zone database in /usr/share/zoneinfo",
+		getAssets:   getTzdataAssets,
+	},
+	"etc-files": {
+		description: "/etc/passwd and /etc/group with root, nobody and the app's user and group, and /etc/nsswitch.conf",
+		getAssets:   getEtcFilesAssets,
+	},
+}
+
+// caCertsPaths are the locations of CA certificates bundle on
+// various distributions.
+var caCertsPaths = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// GetBundles returns a sorted list of descriptions of available
+// bundles.
+func GetBundles() []string {
+	names := make([]string, 0, len(bundles))
+	for name := range bundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	descriptions := make([]string, 0, len(names))
+	for _, name := range names {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%s)", name, bundles[name].description))
+	}
+	return descriptions
+}
+
+// parseBundle splits a bundle specification ("<name>[=<dir>]") into
+// the bundle and the directory to take the files from.
+func parseBundle(spec string) (bundle, string, error) {
+	kv := strings.SplitN(spec, "=", 2)
+	b, ok := bundles[kv[0]]
+	if !ok {
+		return bundle{}, "", fmt.Errorf("Unknown bundle %q", kv[0])
+	}
+	if len(kv) == 1 {
+		return b, "", nil
+	}
+	if !filepath.IsAbs(kv[1]) {
+		return bundle{}, "", fmt.Errorf("Directory of bundle %q has to be an absolute path", kv[0])
+	}
+	return b, kv[1], nil
+}
+
+// validateBundles checks if the given bundle specifications are
+// valid.
+func validateBundles(specs []string) error {
+	for _, spec := range splitBundleSpecs(specs) {
+		if _, _, err := parseBundle(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitBundleSpecs splits comma separated lists of bundle
+// specifications.
+func splitBundleSpecs(specs []string) []string {
+	split := []string{}
+	for _, spec := range specs {
+		for _, s := range strings.Split(spec, ",") {
+			if s != "" {
+				split = append(split, s)
+			}
+		}
+	}
+	return split
+}
+
+// getBundleAssets returns the assets of the given bundles.
+func getBundleAssets(specs []string, ctx bundleContext) ([]string, error) {
+	assets := []string{}
+	for _, spec := range splitBundleSpecs(specs) {
+		b, sourceDir, err := parseBundle(spec)
+		if err != nil {
+			return nil, err
+		}
+		ctx.sourceDir = sourceDir
+		bundleAssets, err := b.getAssets(&ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to prepare bundle %q: %v", spec, err)
+		}
+		assets = append(assets, bundleAssets...)
+	}
+	return assets, nil
+}
+
+// findFile returns the first of the given paths which exist in
+// one of dirs.
+func findFile(dirs []string, paths []string) (string, error) {
+	for _, dir := range dirs {
+		for _, path := range paths {
+			candidate := filepath.Join(dir, path)
+			if fi, err := os.Stat(candidate); err == nil && !fi.IsDir() {
+				return candidate, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("None of %s found in %s", strings.Join(paths, ", "), strings.Join(dirs, ", "))
+}
+
+func getCACertsAssets(ctx *bundleContext) ([]string, error) {
+	var path string
+	var err error
+	if ctx.sourceDir != "" {
+		basenames := make([]string, 0, len(caCertsPaths))
+		for _, p := range caCertsPaths {
+			basenames = append(basenames, filepath.Base(p))
+		}
+		if path, err = findFile([]string{ctx.sourceDir}, basenames); err == nil {
+			path, err = filepath.EvalSymlinks(path)
+		}
+	} else {
+		// the bundle is often a symlink to some other file
+		if path, err = findFile([]string{ctx.sysroot}, caCertsPaths); err == nil {
+			path, err = evalSymlinksInRoot(ctx.sysroot, path)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []string{GetAssetString(caCertsPaths[0], path)}, nil
+}
+
+func getTzdataAssets(ctx *bundleContext) ([]string, error) {
+	const zoneinfo = "/usr/share/zoneinfo"
+	dir := ctx.sourceDir
+	if dir == "" {
+		dir = filepath.Join(ctx.sysroot, zoneinfo)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("Time zone database not found in %q", dir)
+	}
+	return getZoneinfoAssets(zoneinfo, dir, zoneinfo, dir)
+}
+
+// getZoneinfoAssets returns the assets for the contents of a time zone
+// database directory. The symlinks pointing outside of the database
+// (like localtime pointing to /etc/localtime on Debian) are left out,
+// they would be dangling in the ACI rootfs. Directories without such
+// symlinks are copied with a single asset.
+func getZoneinfoAssets(aciDir, dir, zoneinfo, root string) ([]string, error) {
+	outside, err := hasOutsideSymlinks(aciDir, dir, zoneinfo, root)
+	if err != nil {
+		return nil, err
+	}
+	if !outside {
+		return []string{GetAssetString(aciDir+"/", dir+"/")}, nil
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	assets := []string{}
+	for _, entry := range entries {
+		aciPath := filepath.Join(aciDir, entry.Name())
+		path := filepath.Join(dir, entry.Name())
+		switch {
+		case entry.IsDir():
+			dirAssets, err := getZoneinfoAssets(aciPath, path, zoneinfo, root)
+			if err != nil {
+				return nil, err
+			}
+			assets = append(assets, dirAssets...)
+		case isSymlink(entry.Mode()):
+			target, err := os.Readlink(path)
+			if err != nil {
+				return nil, err
+			}
+			if !isZoneinfoLink(aciDir, target, zoneinfo, root) {
+				Debug("skipping ", path, ", it points outside of the time zone database to ", target)
+				continue
+			}
+			assets = append(assets, GetAssetString(aciPath, path))
+		default:
+			assets = append(assets, GetAssetString(aciPath, path))
+		}
+	}
+	return assets, nil
+}
+
+// hasOutsideSymlinks checks if dir has symlinks pointing outside of
+// the time zone database.
+func hasOutsideSymlinks(aciDir, dir, zoneinfo, root string) (bool, error) {
+	outside := false
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || outside || !isSymlink(info.Mode()) {
+			return err
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := getSubPath(dir, filepath.Dir(path))
+		if !isZoneinfoLink(filepath.Join(aciDir, rel), target, zoneinfo, root) {
+			outside = true
+		}
+		return nil
+	})
+	return outside, err
+}
+
+// isZoneinfoLink checks if a symlink in aciDir points inside the time
+// zone database, which is in zoneinfo in the ACI rootfs and in root on
+// the local filesystem.
+func isZoneinfoLink(aciDir, target, zoneinfo, root string) bool {
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(aciDir, target)
+	} else if _, ok := getSubPath(root, target); ok {
+		return true
+	}
+	_, ok := getSubPath(zoneinfo, target)
+	return ok
+}
+
+func getEtcFilesAssets(ctx *bundleContext) ([]string, error) {
+	files := []string{"passwd", "group", "nsswitch.conf"}
+	dir := ctx.sourceDir
+	if dir == "" {
+		dir = filepath.Join(ctx.genDir, "etc-files")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		contents := []string{
+			basePasswd,
+			baseGroup,
+			"passwd: files\ngroup: files\nshadow: files\nhosts: files dns\n",
+		}
+		for i, file := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(contents[i]), 0644); err != nil {
+				return nil, err
+			}
+		}
+	}
+	assets := make([]string, 0, len(files))
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		assets = append(assets, GetAssetString(filepath.Join("/etc", file), path))
+	}
+	return assets, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTzdataBundleOutsideSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goaci-bundle-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sysroot := filepath.Join(dir, "sysroot")
+	zoneinfo := filepath.Join(sysroot, "usr/share/zoneinfo")
+	rootfs := filepath.Join(dir, "rootfs")
+	for _, d := range []string{filepath.Join(zoneinfo, "Europe"), filepath.Join(zoneinfo, "Etc"), rootfs} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"UTC", "Europe/Berlin", "Etc/UTC"} {
+		if err := ioutil.WriteFile(filepath.Join(zoneinfo, file), []byte("TZif"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"localtime":     "/etc/localtime",
+		"Etc/localtime": "../../../../etc/localtime",
+		"Zulu":          "UTC",
+		"Europe/Bonn":   "/usr/share/zoneinfo/Europe/Berlin",
+		"Etc/Zulu":      "../UTC",
+		"posix":         ".",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(zoneinfo, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assets, err := getBundleAssets([]string{"tzdata"}, bundleContext{sysroot: sysroot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PrepareAssets(assets, rootfs, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	dangling, err := findDanglingSymlinks(rootfs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dangling) > 0 {
+		t.Errorf("unexpected dangling symlinks: %v", dangling)
+	}
+	for _, file := range []string{"UTC", "Europe/Berlin", "Etc/UTC", "Zulu", "Europe/Bonn", "Etc/Zulu", "posix"} {
+		if _, err := os.Lstat(filepath.Join(rootfs, "usr/share/zoneinfo", file)); err != nil {
+			t.Errorf("expected %s in rootfs: %v", file, err)
+		}
+	}
+	for _, file := range []string{"localtime", "Etc/localtime"} {
+		if _, err := os.Lstat(filepath.Join(rootfs, "usr/share/zoneinfo", file)); !os.IsNotExist(err) {
+			t.Errorf("expected no %s in rootfs, got %v", file, err)
+		}
+	}
+}
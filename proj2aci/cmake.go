@@ -15,6 +15,7 @@
 package proj2aci
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -91,67 +92,88 @@ func (custom *CmakeCustomizations) GetDirectoriesToMake() []string {
 		custom.paths.build,
 		custom.paths.install,
 	}
-	// not creating custom.paths.src, because go.vcs requires the
-	// src directory to be nonexistent
+	// not creating custom.paths.src, because the VCS commands
+	// require the src directory to be nonexistent
 	return dirs
 }
 
-func (custom *CmakeCustomizations) PrepareProject() error {
+func (custom *CmakeCustomizations) PrepareProject(ctx context.Context) error {
 	if custom.Configuration.ReuseSrcDir == "" {
-		if err := custom.createRepo(); err != nil {
+		if err := custom.createRepo(ctx); err != nil {
 			return err
 		}
 	}
 
 	Info("Running cmake")
-	if err := custom.runCmake(); err != nil {
+	if err := custom.runCmake(ctx); err != nil {
 		return err
 	}
 
 	Info("Running make")
-	if err := custom.runMake(); err != nil {
+	if err := custom.runMake(ctx); err != nil {
 		return err
 	}
 
 	Info("Running make install")
-	if err := custom.runMakeInstall(); err != nil {
+	if err := custom.runMakeInstall(ctx); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (custom *CmakeCustomizations) createRepo() error {
+// vcsCreateArgs maps the VCS commands to their arguments for
+// downloading a fresh copy of a repository with a working copy.
+var vcsCreateArgs = map[string][]string{
+	"git": {"clone"},
+	"hg":  {"clone"},
+	"bzr": {"branch"},
+	"svn": {"checkout"},
+}
+
+// createRepo downloads the project into the src directory. The VCS
+// command is run with RunCmd instead of vcs.Cmd.Create, so it is
+// killed when ctx is done.
+func (custom *CmakeCustomizations) createRepo(ctx context.Context) error {
 	Info(fmt.Sprintf("Downloading %s", custom.Configuration.Project))
 	repo, err := vcs.RepoRootForImportPath(custom.Configuration.Project, false)
 	if err != nil {
 		return err
 	}
-	return repo.VCS.Create(custom.paths.src, repo.Repo)
+	createArgs, ok := vcsCreateArgs[repo.VCS.Cmd]
+	if !ok {
+		return fmt.Errorf("Unsupported VCS %q of %s", repo.VCS.Cmd, custom.Configuration.Project)
+	}
+	if err := os.MkdirAll(filepath.Dir(custom.paths.src), 0755); err != nil {
+		return err
+	}
+	args := append([]string{repo.VCS.Cmd}, createArgs...)
+	args = append(args, repo.Repo, custom.paths.src)
+	return RunCmd(ctx, args, nil, "")
 }
 
-func (custom *CmakeCustomizations) runCmake() error {
+func (custom *CmakeCustomizations) runCmake(ctx context.Context) error {
 	args := []string{"cmake"}
 	args = append(args, custom.Configuration.CmakeParams...)
 	args = append(args, custom.paths.src)
-	return RunCmd(args, nil, custom.paths.build)
+	return RunCmd(ctx, args, nil, custom.paths.build)
 }
 
-func (custom *CmakeCustomizations) runMake() error {
+func (custom *CmakeCustomizations) runMake(ctx context.Context) error {
 	args := []string{
 		"make",
 		fmt.Sprintf("-j%d", runtime.NumCPU()),
 	}
-	return RunCmd(args, nil, custom.paths.build)
+	return RunCmd(ctx, args, nil, custom.paths.build)
 }
 
-func (custom *CmakeCustomizations) runMakeInstall() error {
+func (custom *CmakeCustomizations) runMakeInstall(ctx context.Context) error {
 	args := []string{
 		"make",
 		"install",
 	}
 	env := append(os.Environ(), "DESTDIR="+custom.paths.install)
-	return RunCmd(args, env, custom.paths.build)
+	return RunCmd(ctx, args, env, custom.paths.build)
 }
 
 func (custom *CmakeCustomizations) GetPlaceholderMapping() map[string]string {
@@ -171,6 +193,10 @@ func (custom *CmakeCustomizations) GetAssets(aciBinDir string) ([]string, error)
 	return []string{GetAssetString(rootBinary, custom.fullBinPath)}, nil
 }
 
+func (custom *CmakeCustomizations) GetInstallRoots() []string {
+	return []string{custom.paths.install}
+}
+
 func (custom *CmakeCustomizations) getBinDir() (string, error) {
 	if custom.Configuration.BinDir != "" {
 		return filepath.Join(custom.paths.install, custom.Configuration.BinDir), nil
@@ -236,6 +262,18 @@ func (custom *CmakeCustomizations) GetRepoPath() (string, error) {
 	return custom.paths.src, nil
 }
 
+func (custom *CmakeCustomizations) GetToolVersions(ctx context.Context) (map[string]string, error) {
+	versions := make(map[string]string)
+	for _, tool := range []string{"cmake", "make"} {
+		version, err := getToolVersion(ctx, "", tool, "--version")
+		if err != nil {
+			return nil, err
+		}
+		versions[tool] = version
+	}
+	return versions, nil
+}
+
 func (custom *CmakeCustomizations) GetImageFileName() (string, error) {
 	base := filepath.Base(custom.Configuration.Project)
 	if base == "..." {
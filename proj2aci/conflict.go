@@ -0,0 +1,133 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// ConflictError fails when different files are copied to
+	// the same path in the ACI rootfs.
+	ConflictError = "error"
+	// ConflictFirst keeps the file copied first.
+	ConflictFirst = "first"
+	// ConflictLast replaces the file with the one copied last.
+	ConflictLast = "last"
+)
+
+// ValidateConflictPolicy checks if policy is a known conflict
+// policy. An empty policy means ConflictError.
+func ValidateConflictPolicy(policy string) error {
+	switch policy {
+	case "", ConflictError, ConflictFirst, ConflictLast:
+		return nil
+	}
+	return fmt.Errorf("Unknown conflict policy %q, expected %s, %s or %s", policy, ConflictError, ConflictFirst, ConflictLast)
+}
+
+// claimPath records that a local file is going to be copied to the
+// ACI path. If some other file was already copied there, the conflict
+// is resolved according to the conflict policy, files with the same
+// contents do not conflict and the overlays always replace the
+// earlier files. It returns false if the file should not be copied.
+func (p *assetsPreparer) claimPath(ACIPath, localPath string) (bool, error) {
+	owner, ok := p.owners[ACIPath]
+	if !ok {
+		p.owners[ACIPath] = localPath
+		return true, nil
+	}
+	same, err := haveSameContents(owner, localPath)
+	if err != nil {
+		return false, err
+	}
+	if same {
+		Debug("skipping ", localPath, ", the same file was already copied to ", ACIPath)
+		return false, nil
+	}
+	if p.overlaying {
+		Debug("overlay file ", localPath, " replaces ", owner, " in ", ACIPath)
+		p.replaceOwner(ACIPath, localPath)
+		return true, nil
+	}
+	switch p.onConflict {
+	case ConflictFirst:
+		Warn(fmt.Sprintf("Both %q and %q are copied to %q, keeping the first one", owner, localPath, ACIPath))
+		return false, nil
+	case ConflictLast:
+		Warn(fmt.Sprintf("Both %q and %q are copied to %q, keeping the last one", owner, localPath, ACIPath))
+		p.replaceOwner(ACIPath, localPath)
+		return true, nil
+	}
+	return false, fmt.Errorf("Conflicting assets: both %q and %q are copied to %q", owner, localPath, ACIPath)
+}
+
+// replaceOwner records that a local file replaces the file copied to
+// the ACI path before. The replaced copy is forgotten, so the other
+// hardlinks to the replaced file are not linked to its replacement.
+func (p *assetsPreparer) replaceOwner(ACIPath, localPath string) {
+	p.owners[ACIPath] = localPath
+	dest := filepath.Join(p.rootfs, ACIPath)
+	for id, copied := range p.copiedFiles {
+		if copied == dest {
+			delete(p.copiedFiles, id)
+		}
+	}
+}
+
+// haveSameContents checks if two local files are the same file,
+// regular files with the same contents or symlinks with the same
+// target.
+func haveSameContents(path1, path2 string) (bool, error) {
+	fi1, err := os.Lstat(path1)
+	if err != nil {
+		return false, err
+	}
+	fi2, err := os.Lstat(path2)
+	if err != nil {
+		return false, err
+	}
+	if os.SameFile(fi1, fi2) {
+		return true, nil
+	}
+	switch {
+	case isSymlink(fi1.Mode()) && isSymlink(fi2.Mode()):
+		target1, err := os.Readlink(path1)
+		if err != nil {
+			return false, err
+		}
+		target2, err := os.Readlink(path2)
+		if err != nil {
+			return false, err
+		}
+		return target1 == target2, nil
+	case fi1.Mode().IsRegular() && fi2.Mode().IsRegular():
+		if fi1.Size() != fi2.Size() || fi1.Mode().Perm() != fi2.Mode().Perm() {
+			return false, nil
+		}
+		hash1, err := getFileHash(path1)
+		if err != nil {
+			return false, err
+		}
+		hash2, err := getFileHash(path2)
+		if err != nil {
+			return false, err
+		}
+		return hash1 == hash2, nil
+	}
+	return false, nil
+}
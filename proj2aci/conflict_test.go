@@ -0,0 +1,161 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClaimPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goaci-conflict-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, contents := range map[string]string{"a": "one", "b": "two", "same-as-a": "one"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		onConflict string
+		overlaying bool
+		second     string
+		// copied says if the second file should be copied
+		copied bool
+		fail   bool
+		// owner is the file owning the path in the end
+		owner string
+	}{
+		{name: "error policy", onConflict: ConflictError, second: "b", fail: true, owner: "a"},
+		{name: "default policy", second: "b", fail: true, owner: "a"},
+		{name: "first policy", onConflict: ConflictFirst, second: "b", owner: "a"},
+		{name: "last policy", onConflict: ConflictLast, second: "b", copied: true, owner: "b"},
+		{name: "same file", onConflict: ConflictError, second: "a", owner: "a"},
+		{name: "same contents", onConflict: ConflictError, second: "same-as-a", owner: "a"},
+		{name: "same contents in overlay", overlaying: true, second: "same-as-a", owner: "a"},
+		{name: "overlay", onConflict: ConflictFirst, overlaying: true, second: "b", copied: true, owner: "b"},
+		{name: "file and symlink", onConflict: ConflictError, second: "link", fail: true, owner: "a"},
+	}
+	for _, tt := range tests {
+		p := &assetsPreparer{
+			owners:     map[string]string{},
+			onConflict: tt.onConflict,
+		}
+		if ok, err := p.claimPath("/x", filepath.Join(dir, "a")); !ok || err != nil {
+			t.Fatalf("%s: first claim failed: %v, %v", tt.name, ok, err)
+		}
+		p.overlaying = tt.overlaying
+		copied, err := p.claimPath("/x", filepath.Join(dir, tt.second))
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if copied != tt.copied {
+			t.Errorf("%s: expected copied %v, got %v", tt.name, tt.copied, copied)
+		}
+		if owner := p.owners["/x"]; owner != filepath.Join(dir, tt.owner) {
+			t.Errorf("%s: expected owner %q, got %q", tt.name, tt.owner, owner)
+		}
+	}
+}
+
+func TestHaveSameContentsSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goaci-conflict-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, target := range map[string]string{"link1": "a", "link2": "a", "link3": "b"} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		path1 string
+		path2 string
+		same  bool
+	}{
+		{path1: "link1", path2: "link2", same: true},
+		{path1: "link1", path2: "link3"},
+	}
+	for _, tt := range tests {
+		same, err := haveSameContents(filepath.Join(dir, tt.path1), filepath.Join(dir, tt.path2))
+		if err != nil {
+			t.Errorf("%s and %s: unexpected error: %v", tt.path1, tt.path2, err)
+			continue
+		}
+		if same != tt.same {
+			t.Errorf("%s and %s: expected %v, got %v", tt.path1, tt.path2, tt.same, same)
+		}
+	}
+}
+
+// TestConflictLastHardlinks checks that a hardlink to a replaced file
+// is not linked to the file replacing it.
+func TestConflictLastHardlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goaci-conflict-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	rootfs := filepath.Join(dir, "rootfs")
+	for _, d := range []string{src, rootfs} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := filepath.Join(src, "a")
+	a2 := filepath.Join(src, "a2")
+	b := filepath.Join(src, "b")
+	if err := ioutil.WriteFile(a, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(b, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(a, a2); err != nil {
+		t.Fatal(err)
+	}
+	assets := []string{
+		GetAssetString("/x", a),
+		GetAssetString("/x", b),
+		GetAssetString("/y", a2),
+	}
+	if err := PrepareAssets(assets, rootfs, nil, &AssetsOptions{OnConflict: ConflictLast}); err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{"x": "b", "y": "a"} {
+		data, err := ioutil.ReadFile(filepath.Join(rootfs, path))
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if string(data) != expected {
+			t.Errorf("%s: expected %q, got %q", path, expected, data)
+		}
+	}
+}
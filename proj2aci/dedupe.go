@@ -0,0 +1,130 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileID identifies a file on a filesystem, hardlinks to the same
+// file have the same fileID.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// dedupeCandidate is a key for grouping files which may have the same
+// contents and can be replaced with hardlinks to each other.
+type dedupeCandidate struct {
+	size  int64
+	mode  os.FileMode
+	attrs string
+}
+
+// dedupeRootfs replaces regular files in rootfs having the same
+// contents with hardlinks to a single file, so they end up in the ACI
+// only once. Files are linked only if they have the same permissions
+// and the same asset attributes apply to them, because the hardlinks
+// share them too.
+func dedupeRootfs(rootfs string, attrs []*assetAttr) error {
+	candidates := make(map[dedupeCandidate][]string)
+	seen := make(map[fileID]struct{})
+	err := filepath.Walk(rootfs, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || info.Size() == 0 {
+			return nil
+		}
+		if id, ok := getFileID(info); ok {
+			if _, linked := seen[id]; linked {
+				return nil
+			}
+			seen[id] = struct{}{}
+		}
+		aciPath, _ := getSubPath(rootfs, path)
+		key := dedupeCandidate{
+			size:  info.Size(),
+			mode:  info.Mode(),
+			attrs: getMatchingAttrsKey(attrs, aciPath),
+		}
+		candidates[key] = append(candidates[key], path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	saved := int64(0)
+	for key, paths := range candidates {
+		if len(paths) < 2 {
+			continue
+		}
+		byHash := make(map[string]string)
+		for _, path := range paths {
+			hash, err := getFileHash(path)
+			if err != nil {
+				return err
+			}
+			original, ok := byHash[hash]
+			if !ok {
+				byHash[hash] = path
+				continue
+			}
+			Debug("replacing ", path, " with a hardlink to ", original)
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			if err := os.Link(original, path); err != nil {
+				return fmt.Errorf("Failed to hardlink %q to %q: %v", path, original, err)
+			}
+			saved += key.size
+		}
+	}
+	if saved > 0 {
+		Info(fmt.Sprintf("Replaced duplicated files with hardlinks, saved %d bytes", saved))
+	}
+	return nil
+}
+
+// getMatchingAttrsKey returns a string describing which asset
+// attributes apply to the given path.
+func getMatchingAttrsKey(attrs []*assetAttr, aciPath string) string {
+	matching := []string{}
+	for i, attr := range attrs {
+		if attr.matches(aciPath) {
+			matching = append(matching, fmt.Sprint(i))
+		}
+	}
+	return strings.Join(matching, ",")
+}
+
+func getFileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
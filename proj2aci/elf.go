@@ -0,0 +1,495 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// elfObject describes a dynamically linked ELF file. aciPath is a
+// path of the file inside the ACI rootfs and localPath is a path to
+// the file on the build host.
+type elfObject struct {
+	aciPath   string
+	localPath string
+	class     elf.Class
+	machine   elf.Machine
+	interp    string
+	needed    []string
+	rpath     []string
+	runpath   []string
+}
+
+// searchDir is a directory where shared libraries are looked for,
+// both as seen in the ACI rootfs and on the build host.
+type searchDir struct {
+	aci   string
+	local string
+}
+
+// libResolver finds shared libraries needed by ELF files the same way
+// the dynamic linker would, but without running anything - it only
+// reads the dynamic sections of the files. This makes it safe to use
+// on untrusted binaries and allows resolving libraries for binaries
+// built for other architectures, when given a proper sysroot.
+type libResolver struct {
+	// roots are directories mirroring the rootfs layout in which
+	// libraries are searched for. Install roots go first, the
+	// sysroot is the last one.
+	roots []string
+	// libDirs are rootfs paths searched before the RUNPATH
+	// entries, like LD_LIBRARY_PATH.
+	libDirs []string
+	// confDirs are the directories listed in ld.so.conf of the
+	// sysroot, nil until loaded.
+	confDirs []string
+	// readObject parses the ELF files, it is readElfObject unless
+	// replaced by tests.
+	readObject func(aciPath, localPath string) (*elfObject, error)
+}
+
+func newLibResolver(sysroot string, installRoots, libDirs []string) *libResolver {
+	if sysroot == "" {
+		sysroot = "/"
+	}
+	roots := make([]string, 0, len(installRoots)+1)
+	for _, root := range installRoots {
+		roots = append(roots, filepath.Clean(root))
+	}
+	return &libResolver{
+		roots:      append(roots, sysroot),
+		libDirs:    libDirs,
+		readObject: readElfObject,
+	}
+}
+
+// readElfObject parses an ELF file. It returns nil if the file is not
+// an ELF file.
+func readElfObject(aciPath, localPath string) (*elfObject, error) {
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+	isElf, err := hasElfMagic(localPath)
+	if err != nil || !isElf {
+		return nil, err
+	}
+	f, err := elf.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse ELF file %q: %v", localPath, err)
+	}
+	defer f.Close()
+
+	obj := &elfObject{
+		aciPath:   aciPath,
+		localPath: localPath,
+		class:     f.Class,
+		machine:   f.Machine,
+	}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		interp, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read ELF interpreter of %q: %v", localPath, err)
+		}
+		obj.interp = string(bytes.TrimRight(interp, "\x00"))
+	}
+	if obj.needed, err = f.DynString(elf.DT_NEEDED); err != nil {
+		return nil, err
+	}
+	if obj.rpath, err = getDynPathList(f, elf.DT_RPATH); err != nil {
+		return nil, err
+	}
+	if obj.runpath, err = getDynPathList(f, elf.DT_RUNPATH); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func hasElfMagic(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(magic) == elf.ELFMAG, nil
+}
+
+func getDynPathList(f *elf.File, tag elf.DynTag) ([]string, error) {
+	values, err := f.DynString(tag)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, v := range values {
+		for _, p := range strings.Split(v, ":") {
+			if p != "" {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// getSoLibs returns a list of assets for all the shared libraries
+// (and the dynamic linker) needed by the given file, directly or
+// indirectly, together with the reasons for copying them. The list is
+// empty if the file is not a dynamically linked ELF file.
+func (r *libResolver) getSoLibs(aciPath, localPath string) ([]pendingAsset, error) {
+	obj, err := r.readObject(aciPath, localPath)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	assets := []pendingAsset{}
+	if obj.interp != "" {
+		dirs := r.rootDirs(filepath.Dir(obj.interp))
+		interp, err := r.findLib(filepath.Base(obj.interp), obj, dirs)
+		if err != nil {
+			return nil, err
+		}
+		if interp == nil {
+			Warn(fmt.Sprintf("Could not find ELF interpreter %q needed by %q", obj.interp, localPath))
+		} else {
+			symlinkedAssets, err := r.getSymlinkedAssets(obj.interp, interp.localPath)
+			if err != nil {
+				return nil, err
+			}
+			reason := assetReason{
+				kind:   reasonElfInterpreter,
+				parent: aciPath,
+			}
+			assets = append(assets, getPendingAssets(symlinkedAssets, reason)...)
+		}
+	}
+
+	type queuedObject struct {
+		obj *elfObject
+		// loaderRpath are the RPATH entries of objects that
+		// caused loading of obj.
+		loaderRpath []searchDir
+	}
+	found := make(map[string]struct{})
+	queue := []queuedObject{{obj: obj}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		// Like in glibc, an object with RUNPATH ignores all the
+		// RPATH entries, its own and the ones of its loaders.
+		// The libraries it loads still use the RPATH entries of
+		// the loaders, but not its own.
+		inheritedRpath := current.loaderRpath
+		var rpath []searchDir
+		if len(current.obj.runpath) == 0 {
+			inheritedRpath = append(r.expandDirs(current.obj.rpath, current.obj), inheritedRpath...)
+			rpath = inheritedRpath
+		}
+		for _, name := range current.obj.needed {
+			if _, ok := found[name]; ok {
+				continue
+			}
+			lib, err := r.findLib(name, current.obj, r.getSearchDirs(current.obj, rpath))
+			if err != nil {
+				return nil, err
+			}
+			if lib == nil {
+				Warn(fmt.Sprintf("Could not find shared library %q needed by %q", name, current.obj.localPath))
+				continue
+			}
+			found[name] = struct{}{}
+			Debug("found library ", name, " for ", current.obj.localPath, ": ", lib.localPath)
+			symlinkedAssets, err := r.getSymlinkedAssets(lib.aciPath, lib.localPath)
+			if err != nil {
+				return nil, err
+			}
+			reason := assetReason{
+				kind:   reasonSharedLibrary,
+				parent: current.obj.aciPath,
+				detail: name,
+			}
+			assets = append(assets, getPendingAssets(symlinkedAssets, reason)...)
+			queue = append(queue, queuedObject{
+				obj:         lib,
+				loaderRpath: inheritedRpath,
+			})
+		}
+	}
+	return assets, nil
+}
+
+// getSearchDirs returns directories to search for libraries needed by
+// obj, in the order used by the dynamic linker. rpath should already
+// contain the RPATH entries of obj and of its loaders, if they
+// apply.
+func (r *libResolver) getSearchDirs(obj *elfObject, rpath []searchDir) []searchDir {
+	dirs := append([]searchDir{}, rpath...)
+	for _, dir := range r.libDirs {
+		dirs = append(dirs, r.rootDirs(dir)...)
+	}
+	dirs = append(dirs, r.expandDirs(obj.runpath, obj)...)
+	for _, dir := range r.getConfDirs() {
+		dirs = append(dirs, r.rootDirs(dir)...)
+	}
+	for _, dir := range getDefaultLibDirs(obj.class) {
+		dirs = append(dirs, r.rootDirs(dir)...)
+	}
+	return dirs
+}
+
+// expandDirs turns RPATH or RUNPATH entries of obj into search
+// directories, substituting the dynamic string tokens.
+func (r *libResolver) expandDirs(paths []string, obj *elfObject) []searchDir {
+	dirs := []searchDir{}
+	for _, path := range paths {
+		path = replaceDSTs(path, "LIB", getLibDirName(obj.class))
+		if strings.Contains(path, "$ORIGIN") || strings.Contains(path, "${ORIGIN}") {
+			dirs = append(dirs, searchDir{
+				aci:   filepath.Clean(replaceDSTs(path, "ORIGIN", filepath.Dir(obj.aciPath))),
+				local: filepath.Clean(replaceDSTs(path, "ORIGIN", filepath.Dir(obj.localPath))),
+			})
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			Debug("ignoring relative library path ", path, " in ", obj.localPath)
+			continue
+		}
+		if dir := r.installRootDir(path); dir != nil {
+			dirs = append(dirs, *dir)
+			continue
+		}
+		dirs = append(dirs, r.rootDirs(path)...)
+	}
+	return dirs
+}
+
+// replaceDSTs replaces the $NAME and ${NAME} dynamic string tokens
+// with value.
+func replaceDSTs(path, name, value string) string {
+	path = strings.Replace(path, "${"+name+"}", value, -1)
+	return strings.Replace(path, "$"+name, value, -1)
+}
+
+// rootDirs returns search directories for the given rootfs directory,
+// one for each root.
+func (r *libResolver) rootDirs(dir string) []searchDir {
+	dirs := make([]searchDir, 0, len(r.roots))
+	for _, root := range r.roots {
+		dirs = append(dirs, searchDir{
+			aci:   dir,
+			local: filepath.Join(root, dir),
+		})
+	}
+	return dirs
+}
+
+// installRootDir returns a search directory for a path pointing
+// inside one of the install roots (this happens when RPATH or RUNPATH
+// is set to the install prefix during the build). It returns nil if
+// the path is not inside any install root.
+func (r *libResolver) installRootDir(path string) *searchDir {
+	for _, root := range r.roots[:len(r.roots)-1] {
+		if rel, ok := getSubPath(root, path); ok {
+			return &searchDir{
+				aci:   rel,
+				local: filepath.Clean(path),
+			}
+		}
+	}
+	return nil
+}
+
+// findLib looks for a library with the given name in dirs. Libraries
+// for a different architecture than the one of neededBy are skipped,
+// like the dynamic linker does. It returns nil if no suitable library
+// was found.
+func (r *libResolver) findLib(name string, neededBy *elfObject, dirs []searchDir) (*elfObject, error) {
+	if strings.Contains(name, "/") {
+		if !filepath.IsAbs(name) {
+			return nil, nil
+		}
+		dirs = r.rootDirs(filepath.Dir(name))
+		name = filepath.Base(name)
+	}
+	for _, dir := range dirs {
+		localPath := filepath.Join(dir.local, name)
+		if _, err := os.Stat(localPath); err != nil {
+			continue
+		}
+		lib, err := r.readObject(filepath.Join(dir.aci, name), localPath)
+		if err != nil {
+			return nil, err
+		}
+		if lib == nil || lib.class != neededBy.class || lib.machine != neededBy.machine {
+			Debug("skipping incompatible library ", localPath)
+			continue
+		}
+		return lib, nil
+	}
+	return nil, nil
+}
+
+// getConfDirs returns the library directories listed in
+// /etc/ld.so.conf in the sysroot.
+func (r *libResolver) getConfDirs() []string {
+	if r.confDirs == nil {
+		r.confDirs = []string{}
+		r.readLdSoConf("/etc/ld.so.conf", 0)
+	}
+	return r.confDirs
+}
+
+func (r *libResolver) readLdSoConf(path string, depth int) {
+	const maxDepth = 10
+	if depth > maxDepth {
+		Warn(fmt.Sprintf("Too many levels of includes in ld.so.conf (>%d)", maxDepth))
+		return
+	}
+	sysroot := r.roots[len(r.roots)-1]
+	f, err := os.Open(filepath.Join(sysroot, path))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Warn(fmt.Sprintf("Failed to read %q: %v", path, err))
+		}
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "include":
+			for _, pattern := range fields[1:] {
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(path), pattern)
+				}
+				matches, err := filepath.Glob(filepath.Join(sysroot, pattern))
+				if err != nil {
+					Warn(fmt.Sprintf("Invalid include pattern %q in %q: %v", pattern, path, err))
+					continue
+				}
+				for _, match := range matches {
+					r.readLdSoConf(strings.TrimPrefix(match, filepath.Clean(sysroot)), depth+1)
+				}
+			}
+		case fields[0] == "hwcap":
+		default:
+			for _, dir := range strings.FieldsFunc(line, isLdSoConfSeparator) {
+				r.confDirs = append(r.confDirs, filepath.Clean(dir))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		Warn(fmt.Sprintf("Failed to read %q: %v", path, err))
+	}
+}
+
+func isLdSoConfSeparator(c rune) bool {
+	return c == ':' || c == ',' || c == ' ' || c == '\t'
+}
+
+func getLibDirName(class elf.Class) string {
+	if class == elf.ELFCLASS64 {
+		return "lib64"
+	}
+	return "lib"
+}
+
+// getDefaultLibDirs returns the directories always searched by the
+// dynamic linker.
+func getDefaultLibDirs(class elf.Class) []string {
+	dirs := []string{}
+	if class == elf.ELFCLASS64 {
+		dirs = append(dirs, "/lib64", "/usr/lib64")
+	}
+	return append(dirs, "/lib", "/usr/lib")
+}
+
+// localPath returns a path on the build host of the given rootfs
+// path. It is the path in the first root that contains it.
+func (r *libResolver) localPath(path string) string {
+	for _, root := range r.roots {
+		localPath := filepath.Join(root, path)
+		if _, err := os.Lstat(localPath); err == nil {
+			return localPath
+		}
+	}
+	return filepath.Join(r.roots[len(r.roots)-1], path)
+}
+
+// getSymlinkedAssets returns an array of many assets if given path is
+// a symlink - useful for getting shared libraries, which are often
+// surrounded with a bunch of symlinks. Absolute symlinks are
+// resolved inside the roots.
+func (r *libResolver) getSymlinkedAssets(aciPath, localPath string) ([]string, error) {
+	assets := []string{}
+	maxLevels := 100
+	levels := maxLevels
+	for {
+		if levels < 1 {
+			return nil, fmt.Errorf("Too many levels of symlinks (>%d)", maxLevels)
+		}
+		fi, err := os.Lstat(localPath)
+		if err != nil {
+			return nil, err
+		}
+		asset := getAssetString(aciPath, localPath)
+		assets = append(assets, asset)
+		if !isSymlink(fi.Mode()) {
+			break
+		}
+		symTarget, err := os.Readlink(localPath)
+		if err != nil {
+			return nil, err
+		}
+		if dir := r.installRootDir(symTarget); dir != nil {
+			// the symlink points into the install root
+			// instead of the final location
+			aciPath = dir.aci
+			localPath = dir.local
+		} else if filepath.IsAbs(symTarget) {
+			aciPath = symTarget
+			localPath = r.localPath(symTarget)
+		} else {
+			aciPath = filepath.Join(filepath.Dir(aciPath), symTarget)
+			localPath = filepath.Join(filepath.Dir(localPath), symTarget)
+		}
+		levels--
+	}
+	return assets, nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeElfObject describes an ELF file of a fake rootfs, only the
+// dynamic section entries are given.
+type fakeElfObject struct {
+	needed  []string
+	rpath   []string
+	runpath []string
+}
+
+// newFakeLibResolver creates the files in sysroot and returns a
+// resolver reading the fake objects instead of parsing the files.
+func newFakeLibResolver(t *testing.T, sysroot string, objects map[string]fakeElfObject) *libResolver {
+	for path := range objects {
+		local := filepath.Join(sysroot, path)
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(local, nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := newLibResolver(sysroot, nil, nil)
+	r.readObject = func(aciPath, localPath string) (*elfObject, error) {
+		rel, ok := getSubPath(sysroot, localPath)
+		if !ok {
+			t.Fatalf("%q is outside the sysroot", localPath)
+		}
+		fake, ok := objects[rel]
+		if !ok {
+			return nil, nil
+		}
+		return &elfObject{
+			aciPath:   aciPath,
+			localPath: localPath,
+			class:     elf.ELFCLASS64,
+			machine:   elf.EM_X86_64,
+			needed:    fake.needed,
+			rpath:     fake.rpath,
+			runpath:   fake.runpath,
+		}, nil
+	}
+	return r
+}
+
+func TestGetSoLibs(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects map[string]fakeElfObject
+		// libs maps the expected ACI paths of the libraries to
+		// their paths relative to the sysroot
+		libs map[string]string
+	}{
+		{
+			name: "RPATH is inherited by the loaded libraries",
+			objects: map[string]fakeElfObject{
+				"/app/bin/exe":       {needed: []string{"liba.so"}, rpath: []string{"/opt/a/lib"}},
+				"/opt/a/lib/liba.so": {needed: []string{"libb.so"}},
+				"/opt/a/lib/libb.so": {},
+				"/usr/lib/libb.so":   {},
+			},
+			libs: map[string]string{
+				"/opt/a/lib/liba.so": "/opt/a/lib/liba.so",
+				"/opt/a/lib/libb.so": "/opt/a/lib/libb.so",
+			},
+		},
+		{
+			name: "RUNPATH ignores own and inherited RPATH",
+			objects: map[string]fakeElfObject{
+				"/app/bin/exe":       {needed: []string{"liba.so"}, rpath: []string{"/opt/a/lib"}},
+				"/opt/a/lib/liba.so": {needed: []string{"libx.so"}, rpath: []string{"/opt/a/lib"}, runpath: []string{"/opt/r/lib"}},
+				"/opt/a/lib/libx.so": {},
+				"/opt/r/lib/libx.so": {},
+			},
+			libs: map[string]string{
+				"/opt/a/lib/liba.so": "/opt/a/lib/liba.so",
+				"/opt/r/lib/libx.so": "/opt/r/lib/libx.so",
+			},
+		},
+		{
+			name: "libraries loaded by an object with RUNPATH inherit the RPATH of its loaders",
+			objects: map[string]fakeElfObject{
+				"/app/bin/exe":         {needed: []string{"liba.so"}, rpath: []string{"/opt/a/lib"}},
+				"/opt/a/lib/liba.so":   {needed: []string{"libb.so"}, rpath: []string{"/opt/own/lib"}, runpath: []string{"/opt/r/lib"}},
+				"/opt/r/lib/libb.so":   {needed: []string{"libc.so", "libd.so"}},
+				"/opt/a/lib/libc.so":   {},
+				"/opt/own/lib/libd.so": {},
+				"/usr/lib/libd.so":     {},
+			},
+			libs: map[string]string{
+				"/opt/a/lib/liba.so": "/opt/a/lib/liba.so",
+				"/opt/r/lib/libb.so": "/opt/r/lib/libb.so",
+				"/opt/a/lib/libc.so": "/opt/a/lib/libc.so",
+				"/usr/lib/libd.so":   "/usr/lib/libd.so",
+			},
+		},
+		{
+			name: "$ORIGIN",
+			objects: map[string]fakeElfObject{
+				"/app/bin/exe":             {needed: []string{"liba.so"}, runpath: []string{"$ORIGIN/../lib"}},
+				"/app/lib/liba.so":         {needed: []string{"libb.so"}, rpath: []string{"${ORIGIN}/plugins"}},
+				"/app/lib/plugins/libb.so": {},
+			},
+			libs: map[string]string{
+				"/app/lib/liba.so":         "/app/lib/liba.so",
+				"/app/lib/plugins/libb.so": "/app/lib/plugins/libb.so",
+			},
+		},
+		{
+			name: "default directories in the sysroot",
+			objects: map[string]fakeElfObject{
+				"/app/bin/exe":         {needed: []string{"libz.so.1", "libmissing.so"}},
+				"/usr/lib64/libz.so.1": {},
+				"/usr/lib/libz.so.1":   {},
+			},
+			libs: map[string]string{
+				"/usr/lib64/libz.so.1": "/usr/lib64/libz.so.1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		sysroot, err := ioutil.TempDir("", "goaci-elf-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(sysroot)
+		r := newFakeLibResolver(t, sysroot, tt.objects)
+		assets, err := r.getSoLibs("/app/bin/exe", filepath.Join(sysroot, "app/bin/exe"))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		libs := map[string]string{}
+		for _, asset := range assets {
+			paths := filepath.SplitList(asset.asset)
+			rel, ok := getSubPath(sysroot, paths[1])
+			if !ok {
+				t.Errorf("%s: %q is outside the sysroot", tt.name, paths[1])
+			}
+			libs[paths[0]] = rel
+		}
+		if !reflect.DeepEqual(libs, tt.libs) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.libs, libs)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// assetExcludePresets maps preset names to lists of exclude patterns.
+var assetExcludePresets = map[string][]string{
+	// runtime-only drops files needed only for development or
+	// documentation.
+	// The directories are anchored to where they are installed,
+	// so application directories with the same names are kept.
+	"runtime-only": {
+		"**/include/",
+		"**/lib*/pkgconfig/",
+		"**/lib/*-linux-*/pkgconfig/",
+		"**/share/pkgconfig/",
+		"*.a",
+		"*.la",
+		"**/share/man/",
+		"**/share/doc/",
+		"**/share/info/",
+	},
+	// no-vcs drops version control metadata.
+	"no-vcs": {
+		".git",
+		".gitignore",
+		".hg",
+		".svn",
+		".bzr",
+	},
+}
+
+// GetAssetExcludePresets returns a sorted list of available exclude
+// presets.
+func GetAssetExcludePresets() []string {
+	presets := make([]string, 0, len(assetExcludePresets))
+	for p := range assetExcludePresets {
+		presets = append(presets, p)
+	}
+	sort.Strings(presets)
+	return presets
+}
+
+// GetAssetExcludePreset returns exclude patterns of a given preset.
+func GetAssetExcludePreset(name string) ([]string, error) {
+	patterns, ok := assetExcludePresets[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown asset exclude preset %q, available presets: %s", name, strings.Join(GetAssetExcludePresets(), ", "))
+	}
+	return patterns, nil
+}
+
+// excludePattern is a parsed exclude pattern.
+type excludePattern struct {
+	pattern  string
+	dirOnly  bool
+	anchored bool
+}
+
+// pathFilter decides which paths in ACI rootfs should not be copied.
+//
+// The pattern syntax is similar to the one of .gitignore files. A
+// pattern with a trailing slash matches only directories. A pattern
+// containing other slashes is matched against the whole path in the
+// ACI rootfs and may contain "**" elements. Otherwise it is matched
+// against the base name of the path. Excluding a directory excludes
+// everything inside it.
+type pathFilter struct {
+	patterns []excludePattern
+}
+
+func newPathFilter(patterns []string) (*pathFilter, error) {
+	filter := &pathFilter{}
+	for _, p := range patterns {
+		parsed := excludePattern{
+			pattern: strings.TrimSuffix(p, "/"),
+			dirOnly: strings.HasSuffix(p, "/"),
+		}
+		parsed.anchored = strings.Contains(parsed.pattern, "/")
+		if parsed.pattern == "" {
+			return nil, fmt.Errorf("Invalid exclude pattern %q", p)
+		}
+		if err := validateGlob(parsed.pattern); err != nil {
+			return nil, fmt.Errorf("Invalid exclude pattern %q: %v", p, err)
+		}
+		filter.patterns = append(filter.patterns, parsed)
+	}
+	return filter, nil
+}
+
+// excludes checks if the given path in ACI rootfs should be skipped.
+func (filter *pathFilter) excludes(aciPath string, isDir bool) bool {
+	for _, p := range filter.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		var matched bool
+		if p.anchored {
+			matched, _ = matchGlob(p.pattern, aciPath)
+		} else {
+			matched, _ = filepath.Match(p.pattern, filepath.Base(aciPath))
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"testing"
+)
+
+func TestAssetExcludePresets(t *testing.T) {
+	tests := []struct {
+		preset   string
+		path     string
+		isDir    bool
+		excluded bool
+	}{
+		{"runtime-only", "/usr/include", true, true},
+		{"runtime-only", "/usr/local/include", true, true},
+		{"runtime-only", "/usr/lib/pkgconfig", true, true},
+		{"runtime-only", "/usr/lib64/pkgconfig", true, true},
+		{"runtime-only", "/usr/lib/x86_64-linux-gnu/pkgconfig", true, true},
+		{"runtime-only", "/usr/share/pkgconfig", true, true},
+		{"runtime-only", "/usr/lib/libfoo.a", false, true},
+		{"runtime-only", "/usr/lib/libfoo.la", false, true},
+		{"runtime-only", "/usr/share/man", true, true},
+		{"runtime-only", "/usr/local/share/doc", true, true},
+		{"runtime-only", "/usr/share/info", true, true},
+		{"runtime-only", "/usr/lib/libfoo.so", false, false},
+		{"runtime-only", "/app/doc", true, false},
+		{"runtime-only", "/app/man", true, false},
+		{"runtime-only", "/usr/lib/python3/site-packages/foo/info", true, false},
+		{"runtime-only", "/usr/share/doc", false, false},
+		{"runtime-only", "/app/pkgconfig", true, false},
+		{"no-vcs", "/app/.git", true, true},
+		{"no-vcs", "/app/src/.gitignore", false, true},
+		{"no-vcs", "/app/.svn", true, true},
+		{"no-vcs", "/app/git", true, false},
+	}
+	for _, tt := range tests {
+		patterns, err := GetAssetExcludePreset(tt.preset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := newPathFilter(patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if excluded := filter.excludes(tt.path, tt.isDir); excluded != tt.excluded {
+			t.Errorf("%s: %s (dir: %v): expected excluded %v, got %v", tt.preset, tt.path, tt.isDir, tt.excluded, excluded)
+		}
+	}
+	if _, err := GetAssetExcludePreset("unknown"); err == nil {
+		t.Error("expected an error for an unknown preset")
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		isDir    bool
+		excluded bool
+	}{
+		{"*.pyc", "/app/x/y.pyc", false, true},
+		{"*.pyc", "/app/x/y.py", false, false},
+		{"cache/", "/app/cache", true, true},
+		{"cache/", "/app/cache", false, false},
+		{"/app/tests", "/app/tests", true, true},
+		{"/app/tests", "/lib/app/tests", true, false},
+		{"/app/**/*.md", "/app/README.md", false, true},
+		{"/app/**/*.md", "/app/a/b/c.md", false, true},
+		{"/app/**/*.md", "/opt/a.md", false, false},
+	}
+	for _, tt := range tests {
+		filter, err := newPathFilter([]string{tt.pattern})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if excluded := filter.excludes(tt.path, tt.isDir); excluded != tt.excluded {
+			t.Errorf("%q: %s (dir: %v): expected excluded %v, got %v", tt.pattern, tt.path, tt.isDir, tt.excluded, excluded)
+		}
+	}
+	for _, pattern := range []string{"", "/", "[", "/a/[/b"} {
+		if _, err := newPathFilter([]string{pattern}); err == nil {
+			t.Errorf("%q: expected an error", pattern)
+		}
+	}
+}
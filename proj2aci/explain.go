@@ -0,0 +1,214 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// ExplainFormatTree prints the assets as a tree, each
+	// asset below the one which caused copying it.
+	ExplainFormatTree = "tree"
+	// ExplainFormatDot prints the assets as a Graphviz DOT
+	// graph.
+	ExplainFormatDot = "dot"
+)
+
+// reasonKind is a kind of reason for copying an asset.
+type reasonKind int
+
+const (
+	reasonExplicit reasonKind = iota
+	reasonElfInterpreter
+	reasonSharedLibrary
+	reasonLibRule
+	reasonScriptInterpreter
+	reasonOverlay
+	// reasonContents is the reason of the files copied from
+	// inside a directory asset or an overlay.
+	reasonContents
+)
+
+// assetReason says why an asset was copied.
+type assetReason struct {
+	kind reasonKind
+	// parent is the ACI path of the asset which caused copying,
+	// it is empty for explicit assets.
+	parent string
+	// detail is the name of the needed library or the pattern of
+	// the library rule.
+	detail string
+}
+
+// pendingAsset is an asset waiting to be processed together with the
+// reason for processing it.
+type pendingAsset struct {
+	asset  string
+	reason assetReason
+}
+
+// getPendingAssets gives the same reason to all the assets.
+func getPendingAssets(assets []string, reason assetReason) []pendingAsset {
+	pending := make([]pendingAsset, 0, len(assets))
+	for _, asset := range assets {
+		pending = append(pending, pendingAsset{
+			asset:  asset,
+			reason: reason,
+		})
+	}
+	return pending
+}
+
+// label returns a short description of the reason, without the
+// parent.
+func (r assetReason) label() string {
+	switch r.kind {
+	case reasonElfInterpreter:
+		return "ELF interpreter"
+	case reasonSharedLibrary:
+		return fmt.Sprintf("shared library %s", r.detail)
+	case reasonLibRule:
+		return fmt.Sprintf("library rule %s", r.detail)
+	case reasonScriptInterpreter:
+		return "script interpreter"
+	case reasonOverlay:
+		return "overlay"
+	case reasonContents:
+		return "contents"
+	}
+	return "explicit asset"
+}
+
+func (r assetReason) String() string {
+	if r.parent == "" {
+		return r.label()
+	}
+	return fmt.Sprintf("%s of %s", r.label(), r.parent)
+}
+
+// ValidateExplainFormat checks if format is a known format of the
+// asset explanation. An empty format means no explanation.
+func ValidateExplainFormat(format string) error {
+	switch format {
+	case "", ExplainFormatTree, ExplainFormatDot:
+		return nil
+	}
+	return fmt.Errorf("Unknown asset explanation format %q, expected %s or %s", format, ExplainFormatTree, ExplainFormatDot)
+}
+
+// assetGraph records the reasons for copying the assets.
+type assetGraph struct {
+	// paths are the ACI paths of the assets in the order they
+	// were added.
+	paths   []string
+	reasons map[string][]assetReason
+}
+
+func newAssetGraph() *assetGraph {
+	return &assetGraph{
+		reasons: make(map[string][]assetReason),
+	}
+}
+
+// add records a reason for copying an asset to the ACI path, a path
+// can have many reasons.
+func (g *assetGraph) add(aciPath string, reason assetReason) {
+	reasons, ok := g.reasons[aciPath]
+	if !ok {
+		g.paths = append(g.paths, aciPath)
+	}
+	for _, r := range reasons {
+		if r == reason {
+			return
+		}
+	}
+	g.reasons[aciPath] = append(reasons, reason)
+}
+
+// write writes the graph in the given format.
+func (g *assetGraph) write(w io.Writer, format string) error {
+	switch format {
+	case ExplainFormatTree:
+		return g.writeTree(w)
+	case ExplainFormatDot:
+		return g.writeDot(w)
+	}
+	return ValidateExplainFormat(format)
+}
+
+// writeTree writes the assets as a tree. An asset is printed below
+// the first asset which caused copying it, only the number of the
+// other reasons is printed (they are all in the DOT graph).
+func (g *assetGraph) writeTree(w io.Writer) error {
+	children := make(map[string][]string)
+	roots := []string{}
+	for _, path := range g.paths {
+		first := g.reasons[path][0]
+		if _, known := g.reasons[first.parent]; first.parent == "" || !known {
+			roots = append(roots, path)
+			continue
+		}
+		children[first.parent] = append(children[first.parent], path)
+	}
+	var err error
+	var writeNode func(path string, depth int)
+	writeNode = func(path string, depth int) {
+		if err != nil {
+			return
+		}
+		reasons := g.reasons[path]
+		label := reasons[0].label()
+		if len(reasons) > 1 {
+			label += fmt.Sprintf(", %d more reasons", len(reasons)-1)
+		}
+		_, err = fmt.Fprintf(w, "%s%s (%s)\n", strings.Repeat("  ", depth), path, label)
+		for _, child := range children[path] {
+			writeNode(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		writeNode(root, 0)
+	}
+	return err
+}
+
+// writeDot writes the assets as a Graphviz DOT graph, with the
+// explicit assets and the overlays marked with a double border.
+func (g *assetGraph) writeDot(w io.Writer) error {
+	lines := []string{"digraph assets {", "\trankdir=LR;", "\tnode [shape=box];"}
+	for _, path := range g.paths {
+		attrs := ""
+		for _, r := range g.reasons[path] {
+			if r.kind == reasonExplicit || r.kind == reasonOverlay {
+				attrs = " [peripheries=2]"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("\t%q%s;", path, attrs))
+	}
+	for _, path := range g.paths {
+		for _, r := range g.reasons[path] {
+			if r.parent == "" {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("\t%q -> %q [label=%q];", r.parent, path, r.label()))
+		}
+	}
+	lines = append(lines, "}")
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExplainDirectoryContents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goaci-explain-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rootfs := filepath.Join(dir, "rootfs")
+	for _, file := range []string{"www/index.html", "www/css/site.css", "www/skip.tmp", "overlay/etc/app.conf"} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	output := new(bytes.Buffer)
+	options := &AssetsOptions{
+		Excludes:      []string{"*.tmp"},
+		Overlays:      []string{filepath.Join(dir, "overlay")},
+		ExplainFormat: ExplainFormatTree,
+		ExplainOutput: output,
+	}
+	assets := []string{GetAssetString("/srv/www", filepath.Join(dir, "www"))}
+	if err := PrepareAssets(assets, rootfs, nil, options); err != nil {
+		t.Fatal(err)
+	}
+	expected := `/srv/www (explicit asset)
+  /srv/www/css/site.css (contents)
+  /srv/www/index.html (contents)
+/etc (overlay)
+  /etc/app.conf (contents)
+`
+	if output.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, output.String())
+	}
+}
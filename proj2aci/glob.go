@@ -0,0 +1,136 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// recursiveWildcard is a path element matching any number of
+// directories.
+const recursiveWildcard = "**"
+
+// hasGlobMeta checks if path contains any wildcards.
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// splitGlob splits a pattern into a directory without wildcards and
+// the rest of the pattern.
+func splitGlob(pattern string) (string, string) {
+	elems := splitPath(pattern)
+	base := []string{}
+	for len(elems) > 0 && !hasGlobMeta(elems[0]) {
+		base = append(base, elems[0])
+		elems = elems[1:]
+	}
+	baseDir := filepath.Join(base...)
+	if filepath.IsAbs(pattern) {
+		baseDir = string(filepath.Separator) + baseDir
+	}
+	return baseDir, filepath.Join(elems...)
+}
+
+// splitPath splits a path into its elements, ignoring empty ones.
+func splitPath(path string) []string {
+	elems := []string{}
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// matchGlob checks if path matches pattern. The pattern syntax is the
+// same as in filepath.Match, additionally a "**" element matches zero
+// or more directories.
+func matchGlob(pattern, path string) (bool, error) {
+	return matchPathElems(splitPath(pattern), splitPath(path))
+}
+
+func matchPathElems(pattern, path []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == recursiveWildcard {
+			for i := 0; i <= len(path); i++ {
+				if matched, err := matchPathElems(pattern[1:], path[i:]); matched || err != nil {
+					return matched, err
+				}
+			}
+			return false, nil
+		}
+		if len(path) == 0 {
+			return false, nil
+		}
+		if matched, err := filepath.Match(pattern[0], path[0]); !matched || err != nil {
+			return false, err
+		}
+		pattern = pattern[1:]
+		path = path[1:]
+	}
+	return len(path) == 0, nil
+}
+
+// validateGlob checks the syntax of a pattern.
+func validateGlob(pattern string) error {
+	for _, elem := range splitPath(pattern) {
+		if _, err := filepath.Match(elem, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandGlob returns a sorted list of paths matching pattern. Unlike
+// filepath.Glob, it supports "**" elements.
+func expandGlob(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, recursiveWildcard) {
+		return filepath.Glob(pattern)
+	}
+	base, rest := splitGlob(pattern)
+	if _, err := os.Lstat(base); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	matches := []string{}
+	err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == base {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		matched, err := matchGlob(rest, rel)
+		if err != nil {
+			return err
+		}
+		if matched {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
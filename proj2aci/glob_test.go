@@ -0,0 +1,128 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		matched bool
+		fail    bool
+	}{
+		{pattern: "/usr/lib/*.so", path: "/usr/lib/libz.so", matched: true},
+		{pattern: "/usr/lib/*.so", path: "/usr/lib/x/libz.so"},
+		{pattern: "/usr/**/*.so", path: "/usr/libz.so", matched: true},
+		{pattern: "/usr/**/*.so", path: "/usr/lib/x86_64-linux-gnu/libz.so", matched: true},
+		{pattern: "/usr/**/*.so", path: "/opt/lib/libz.so"},
+		{pattern: "/etc/**", path: "/etc", matched: true},
+		{pattern: "/etc/**", path: "/etc/ssl/certs/ca.pem", matched: true},
+		{pattern: "/**/doc/**", path: "/usr/share/doc/app/README", matched: true},
+		{pattern: "/**/doc/**", path: "/usr/share/docs/README"},
+		{pattern: "/bin/?", path: "/bin/x", matched: true},
+		{pattern: "/bin/[ab]", path: "/bin/c"},
+		{pattern: "/bin//app/", path: "/bin/app", matched: true},
+		{pattern: "/bin/app", path: "/bin/app/x"},
+		{pattern: "/bin/[", path: "/bin/x", fail: true},
+	}
+	for _, tt := range tests {
+		matched, err := matchGlob(tt.pattern, tt.path)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.pattern)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.pattern, err)
+			continue
+		}
+		if matched != tt.matched {
+			t.Errorf("%q on %q: expected %v, got %v", tt.pattern, tt.path, tt.matched, matched)
+		}
+	}
+}
+
+func TestSplitGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		base    string
+		rest    string
+	}{
+		{pattern: "/usr/lib/*.so", base: "/usr/lib", rest: "*.so"},
+		{pattern: "/usr/**/lib/*.so", base: "/usr", rest: "**/lib/*.so"},
+		{pattern: "/*/bin", base: "/", rest: "*/bin"},
+		{pattern: "lib/**", base: "lib", rest: "**"},
+	}
+	for _, tt := range tests {
+		base, rest := splitGlob(tt.pattern)
+		if base != tt.base || rest != tt.rest {
+			t.Errorf("%q: expected %q and %q, got %q and %q", tt.pattern, tt.base, tt.rest, base, rest)
+		}
+	}
+}
+
+func TestExpandGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goaci-glob-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, file := range []string{"lib/a.so", "lib/b.txt", "lib/x/c.so", "lib/x/y/d.so", "share/e.so"} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		pattern string
+		matches []string
+	}{
+		{pattern: "lib/*.so", matches: []string{"lib/a.so"}},
+		{pattern: "lib/**/*.so", matches: []string{"lib/a.so", "lib/x/c.so", "lib/x/y/d.so"}},
+		{pattern: "**/d.so", matches: []string{"lib/x/y/d.so"}},
+		{pattern: "lib/**/y", matches: []string{"lib/x/y"}},
+		{pattern: "missing/**/*.so", matches: []string{}},
+		{pattern: "lib/*.a", matches: []string{}},
+	}
+	for _, tt := range tests {
+		matches, err := expandGlob(filepath.Join(dir, tt.pattern))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.pattern, err)
+			continue
+		}
+		rels := []string{}
+		for _, match := range matches {
+			rel, err := filepath.Rel(dir, match)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rels = append(rels, rel)
+		}
+		if !reflect.DeepEqual(rels, tt.matches) {
+			t.Errorf("%q: expected %v, got %v", tt.pattern, tt.matches, rels)
+		}
+	}
+}
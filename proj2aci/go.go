@@ -15,9 +15,9 @@
 package proj2aci
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -110,7 +110,7 @@ func (custom *GoCustomizations) GetDirectoriesToMake() []string {
 	}
 }
 
-func (custom *GoCustomizations) PrepareProject() error {
+func (custom *GoCustomizations) PrepareProject(ctx context.Context) error {
 	Info("Running go get")
 	// Construct args for a go get that does a static build
 	args := []string{
@@ -129,19 +129,9 @@ func (custom *GoCustomizations) PrepareProject() error {
 		env = append(env, "GOROOT="+custom.paths.goRoot)
 	}
 
-	cmd := exec.Cmd{
-		Env:    env,
-		Path:   custom.Configuration.GoBinary,
-		Args:   args,
-		Stderr: os.Stderr,
-		Stdout: os.Stdout,
-	}
-	Debug("env: ", cmd.Env)
-	Debug("running command: ", strings.Join(cmd.Args, " "))
-	if err := cmd.Run(); err != nil {
-		return err
-	}
-	return nil
+	Debug("env: ", env)
+	stdout, stderr := getCmdOutput(ctx)
+	return RunCmdFull(ctx, custom.Configuration.GoBinary, args, env, "", stdout, stderr)
 }
 
 func (custom *GoCustomizations) GetPlaceholderMapping() map[string]string {
@@ -162,6 +152,10 @@ func (custom *GoCustomizations) GetAssets(aciBinDir string) ([]string, error) {
 	return []string{GetAssetString(aciAsset, localAsset)}, nil
 }
 
+func (custom *GoCustomizations) GetInstallRoots() []string {
+	return nil
+}
+
 func (custom *GoCustomizations) GetImageName() (*types.ACIdentifier, error) {
 	imageName := custom.Configuration.Project
 	if filepath.Base(imageName) == "..." {
@@ -197,6 +191,14 @@ func (custom *GoCustomizations) GetRepoPath() (string, error) {
 	return custom.paths.project, nil
 }
 
+func (custom *GoCustomizations) GetToolVersions(ctx context.Context) (map[string]string, error) {
+	version, err := getToolVersion(ctx, custom.Configuration.GoBinary, "go", "version")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"go": version}, nil
+}
+
 func (custom *GoCustomizations) GetImageFileName() (string, error) {
 	base := filepath.Base(custom.Configuration.Project)
 	if base == "..." {
@@ -0,0 +1,120 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// HookPrePrepare runs before preparing the project (like
+	// fetching and building it).
+	HookPrePrepare = "pre-prepare"
+	// HookPostPrepare runs after preparing the project.
+	HookPostPrepare = "post-prepare"
+	// HookPreManifest runs after copying the assets, before
+	// preparing the manifest.
+	HookPreManifest = "pre-manifest"
+	// HookPreWrite runs before writing the ACI.
+	HookPreWrite = "pre-write"
+)
+
+// hookPhases are the known hook phases in the order they run.
+var hookPhases = []string{HookPrePrepare, HookPostPrepare, HookPreManifest, HookPreWrite}
+
+// hook is a shell command run at a phase of the build.
+type hook struct {
+	phase   string
+	command string
+}
+
+// GetHookPhases returns the known hook phases in the order they run.
+func GetHookPhases() []string {
+	return append([]string{}, hookPhases...)
+}
+
+// GetHookString returns a properly formatted hook string.
+func GetHookString(phase, command string) string {
+	return fmt.Sprintf("%s=%s", phase, command)
+}
+
+// parseHooks parses hook specifications, like
+// "pre-prepare=make generate".
+func parseHooks(specs []string) ([]*hook, error) {
+	hooks := make([]*hook, 0, len(specs))
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("Malformed hook %q, expected <phase>=<command>", spec)
+		}
+		h := &hook{
+			phase:   kv[0],
+			command: kv[1],
+		}
+		known := false
+		for _, phase := range hookPhases {
+			if h.phase == phase {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown hook phase %q, expected one of %s", h.phase, strings.Join(hookPhases, ", "))
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// getHookEnv returns the environment of the hooks, which is the
+// environment of goaci with variables for the common paths and the
+// placeholders, like GOACI_ROOTFS or GOACI_PROJPATH for <PROJPATH>.
+func getHookEnv(phase, builder string, paths *CommonPaths, placeholders map[string]string) []string {
+	env := append(os.Environ(),
+		"GOACI_PHASE="+phase,
+		"GOACI_BUILDER="+builder,
+		"GOACI_TMPDIR="+paths.TmpDir,
+		"GOACI_ACIDIR="+paths.AciDir,
+		"GOACI_ROOTFS="+paths.RootFS,
+	)
+	names := make([]string, 0, len(placeholders))
+	for placeholder := range placeholders {
+		names = append(names, placeholder)
+	}
+	sort.Strings(names)
+	for _, placeholder := range names {
+		env = append(env, fmt.Sprintf("GOACI_%s=%s", strings.Trim(placeholder, "<>"), placeholders[placeholder]))
+	}
+	return env
+}
+
+// runHooks runs the hooks of the phase in the order they were given.
+// The commands are run with sh -c in the current working directory.
+func runHooks(ctx context.Context, hooks []*hook, phase string, env []string) error {
+	for _, h := range hooks {
+		if h.phase != phase {
+			continue
+		}
+		Info(fmt.Sprintf("Running %s hook %q", phase, h.command))
+		stdout, stderr := getCmdOutput(ctx)
+		if err := RunCmdFull(ctx, "", []string{"sh", "-c", h.command}, env, "", stdout, stderr); err != nil {
+			return fmt.Errorf("Hook %q failed: %v", h.command, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultLibRules are the library rules for the libraries loaded at
+// runtime by glibc with dlopen, so they can't be found by looking at
+// the dynamic sections.
+var defaultLibRules = []string{
+	// name service switch modules, used in networking and for
+	// looking up users
+	"libc.so.*=libnss_*.so.*",
+	// needed by pthread_cancel and pthread_exit
+	"libc.so.*=libgcc_s.so.*",
+	"libpthread.so.*=libgcc_s.so.*",
+	// dns module of name service switch may need the resolver
+	"libnss_dns.so.*=libresolv.so.*",
+}
+
+// libRule says that if a library matching pattern is copied, then
+// files matching companions should be copied too.
+type libRule struct {
+	pattern    string
+	companions []string
+}
+
+// GetDefaultLibRules returns the built-in library rules.
+func GetDefaultLibRules() []string {
+	return append([]string{}, defaultLibRules...)
+}
+
+// ReadLibRulesFile reads library rules from a file, one rule per
+// line. Empty lines and lines starting with # are ignored.
+func ReadLibRulesFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// parseLibRules parses library rules. A rule has a form of
+// "<pattern>=<companion>[,<companion>...]". The pattern is matched
+// against base names of the copied files. Relative companion patterns
+// are looked for in the directory of the matched file, absolute ones
+// in the sysroot (or install roots). Companion patterns may contain
+// "**" elements.
+func parseLibRules(specs []string) ([]*libRule, error) {
+	rules := make([]*libRule, 0, len(specs))
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("Malformed library rule %q - expected <pattern>=<companion>[,<companion>...]", spec)
+		}
+		rule := &libRule{
+			pattern:    kv[0],
+			companions: strings.Split(kv[1], ","),
+		}
+		if _, err := filepath.Match(rule.pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid pattern in library rule %q: %v", spec, err)
+		}
+		for _, companion := range rule.companions {
+			if err := validateGlob(companion); err != nil || companion == "" {
+				return nil, fmt.Errorf("Invalid companion pattern %q in library rule %q", companion, spec)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// getCompanionAssets returns assets for the files which should be
+// copied together with the given asset according to the library
+// rules.
+func (p *assetsPreparer) getCompanionAssets(ACIAsset, localAsset string) ([]pendingAsset, error) {
+	assets := []pendingAsset{}
+	for _, rule := range p.libRules {
+		if matched, _ := filepath.Match(rule.pattern, filepath.Base(localAsset)); !matched {
+			continue
+		}
+		for _, companion := range rule.companions {
+			var dirs []searchDir
+			if filepath.IsAbs(companion) {
+				dirs = p.resolver.rootDirs("/")
+			} else {
+				dirs = []searchDir{{
+					aci:   filepath.Dir(ACIAsset),
+					local: filepath.Dir(localAsset),
+				}}
+			}
+			for _, dir := range dirs {
+				matches, err := expandGlob(filepath.Join(dir.local, companion))
+				if err != nil {
+					return nil, err
+				}
+				for _, match := range matches {
+					rel, err := filepath.Rel(dir.local, match)
+					if err != nil {
+						return nil, err
+					}
+					Debug("library rule ", rule.pattern, " adds ", match)
+					assets = append(assets, pendingAsset{
+						asset: getAssetString(filepath.Join(dir.aci, rel), match),
+						reason: assetReason{
+							kind:   reasonLibRule,
+							parent: ACIAsset,
+							detail: rule.pattern,
+						},
+					})
+				}
+			}
+		}
+	}
+	return assets, nil
+}
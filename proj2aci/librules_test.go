@@ -0,0 +1,147 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseLibRules(t *testing.T) {
+	if _, err := parseLibRules(GetDefaultLibRules()); err != nil {
+		t.Errorf("default rules: %v", err)
+	}
+	tests := []struct {
+		spec     string
+		expected *libRule
+		fail     bool
+	}{
+		{spec: "libc.so.*=libnss_*.so.*", expected: &libRule{pattern: "libc.so.*", companions: []string{"libnss_*.so.*"}}},
+		{spec: "libfoo.so=plugins/**/*.so,/usr/share/foo/*.dat", expected: &libRule{pattern: "libfoo.so", companions: []string{"plugins/**/*.so", "/usr/share/foo/*.dat"}}},
+		{spec: "libfoo.so=a=b", expected: &libRule{pattern: "libfoo.so", companions: []string{"a=b"}}},
+		{spec: "libfoo.so", fail: true},
+		{spec: "=libbar.so", fail: true},
+		{spec: "libfoo.so=", fail: true},
+		{spec: "libfoo.so=a,,b", fail: true},
+		{spec: "lib[.so=libbar.so", fail: true},
+		{spec: "libfoo.so=lib[", fail: true},
+	}
+	for _, tt := range tests {
+		rules, err := parseLibRules([]string{tt.spec})
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(rules[0], tt.expected) {
+			t.Errorf("%q: expected %+v, got %+v", tt.spec, tt.expected, rules[0])
+		}
+	}
+}
+
+func TestReadLibRulesFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "goaci-librules-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("# comment\n\n  libfoo.so=libbar.so  \nlibc.so.*=libnss_*.so.*\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	rules, err := ReadLibRulesFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"libfoo.so=libbar.so", "libc.so.*=libnss_*.so.*"}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %v, got %v", expected, rules)
+	}
+}
+
+func TestGetCompanionAssets(t *testing.T) {
+	sysroot, err := ioutil.TempDir("", "goaci-librules-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sysroot)
+	for _, file := range []string{
+		"lib/libc.so.6",
+		"lib/libnss_dns.so.2",
+		"lib/libnss_files.so.2",
+		"lib/libresolv.so.2",
+		"opt/app/lib/libfoo.so",
+		"opt/app/lib/plugins/x/a.so",
+		"usr/share/foo/data.dat",
+	} {
+		path := filepath.Join(sysroot, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules, err := parseLibRules([]string{
+		"libc.so.*=libnss_*.so.*",
+		"libfoo.so=plugins/**/*.so,/usr/share/foo/*.dat,missing.so",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &assetsPreparer{
+		resolver: newLibResolver(sysroot, nil, nil),
+		libRules: rules,
+	}
+	tests := []struct {
+		asset      string
+		companions []string
+	}{
+		{asset: "/lib/libc.so.6", companions: []string{"/lib/libnss_dns.so.2", "/lib/libnss_files.so.2"}},
+		{asset: "/opt/app/lib/libfoo.so", companions: []string{"/opt/app/lib/plugins/x/a.so", "/usr/share/foo/data.dat"}},
+		{asset: "/lib/libresolv.so.2", companions: []string{}},
+	}
+	for _, tt := range tests {
+		assets, err := p.getCompanionAssets(tt.asset, filepath.Join(sysroot, tt.asset))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.asset, err)
+			continue
+		}
+		companions := []string{}
+		for _, asset := range assets {
+			paths := filepath.SplitList(asset.asset)
+			if paths[1] != filepath.Join(sysroot, paths[0]) {
+				t.Errorf("%q: companion %q copied from %q", tt.asset, paths[0], paths[1])
+			}
+			if asset.reason.parent != tt.asset {
+				t.Errorf("%q: companion %q has parent %q", tt.asset, paths[0], asset.reason.parent)
+			}
+			companions = append(companions, paths[0])
+		}
+		sort.Strings(companions)
+		if !reflect.DeepEqual(companions, tt.companions) {
+			t.Errorf("%q: expected %v, got %v", tt.asset, tt.companions, companions)
+		}
+	}
+}
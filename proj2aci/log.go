@@ -0,0 +1,201 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// LogFormatText prints the log messages as plain lines.
+	LogFormatText = "text"
+	// LogFormatJSON prints the log messages as JSON objects, one
+	// per line, with the time, the level, the message and the
+	// fields.
+	LogFormatJSON = "json"
+)
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	}
+	return fmt.Sprintf("level%d", int(l))
+}
+
+// LogFields are the additional fields of a log message, like "phase",
+// "project" or "builder".
+type LogFields map[string]string
+
+// Logger receives the messages logged with Info, Warn and Debug.
+type Logger interface {
+	Log(level LogLevel, fields LogFields, msg string)
+}
+
+// ValidateLogFormat checks if format is a known log format. An empty
+// format means the text format.
+func ValidateLogFormat(format string) error {
+	switch format {
+	case "", LogFormatText, LogFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("Unknown log format %q, expected %s or %s", format, LogFormatText, LogFormatJSON)
+}
+
+// NewLogger returns a logger printing the messages of at least
+// minLevel in the given format. Warnings go to stderr, other messages
+// go to stdout.
+func NewLogger(format string, minLevel LogLevel, stdout, stderr io.Writer) (Logger, error) {
+	if err := ValidateLogFormat(format); err != nil {
+		return nil, err
+	}
+	return &streamLogger{
+		json:     format == LogFormatJSON,
+		minLevel: minLevel,
+		stdout:   stdout,
+		stderr:   stderr,
+	}, nil
+}
+
+// getLogLevel returns the minimum level of the printed messages.
+// Verbose or GOACI_DEBUG enable debug messages, quiet leaves only
+// warnings.
+func getLogLevel(quiet, verbose bool) LogLevel {
+	switch {
+	case verbose || debugEnabled:
+		return LogDebug
+	case quiet:
+		return LogWarn
+	}
+	return LogInfo
+}
+
+// streamLogger is the logger returned by NewLogger.
+type streamLogger struct {
+	// lock serializes the writes, messages can be logged from
+	// several goroutines (like the one waiting for a signal).
+	lock     sync.Mutex
+	json     bool
+	minLevel LogLevel
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+func (l *streamLogger) Log(level LogLevel, fields LogFields, msg string) {
+	if level < l.minLevel {
+		return
+	}
+	w := l.stdout
+	if level >= LogWarn {
+		w = l.stderr
+	}
+	line := msg
+	if l.json {
+		object := make(map[string]string, len(fields)+3)
+		for k, v := range fields {
+			object[k] = v
+		}
+		object["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+		object["level"] = level.String()
+		object["msg"] = msg
+		data, err := json.Marshal(object)
+		if err != nil {
+			// Marshalling a map of strings does not fail.
+			panic(err)
+		}
+		line = string(data)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	fmt.Fprintln(w, line)
+}
+
+// builderLogger adds the fields describing the build (the builder,
+// the project and the current phase) to the messages logged during
+// Builder.Run.
+type builderLogger struct {
+	lock   sync.Mutex
+	logger Logger
+	fields LogFields
+}
+
+func newBuilderLogger(logger Logger, fields LogFields) *builderLogger {
+	return &builderLogger{
+		logger: logger,
+		fields: fields,
+	}
+}
+
+func (l *builderLogger) setField(key, value string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.fields[key] = value
+}
+
+func (l *builderLogger) Log(level LogLevel, fields LogFields, msg string) {
+	l.lock.Lock()
+	all := make(LogFields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		all[k] = v
+	}
+	l.lock.Unlock()
+	for k, v := range fields {
+		all[k] = v
+	}
+	l.logger.Log(level, all, msg)
+}
+
+var (
+	loggerLock sync.Mutex
+	logger     Logger = &streamLogger{
+		minLevel: LogInfo,
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+	}
+)
+
+// SetLogger makes l the logger receiving the messages logged with
+// Info, Warn and Debug.
+func SetLogger(l Logger) {
+	loggerLock.Lock()
+	defer loggerLock.Unlock()
+	logger = l
+}
+
+// GetLogger returns the logger receiving the messages logged with
+// Info, Warn and Debug.
+func GetLogger() Logger {
+	loggerLock.Lock()
+	defer loggerLock.Unlock()
+	return logger
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// specialNode is a file which can't be simply copied to the rootfs
+// by an unprivileged user, like a device node. Those are written
+// directly to the ACI as tar headers.
+type specialNode struct {
+	path     string
+	typeflag byte
+	major    int64
+	minor    int64
+	mode     int64
+}
+
+// GetSpecialNodeString returns a properly formatted special node
+// string.
+func GetSpecialNodeString(fields ...string) string {
+	return strings.Join(fields, listSeparator())
+}
+
+// parseSpecialNodes parses special node specifications. A
+// specification is an absolute path in the ACI rootfs, a node type (c
+// for character devices, b for block devices, p for FIFOs and d for
+// empty directories), major and minor numbers for devices and an
+// optional octal mode, all separated with the path list separator,
+// like "/dev/null:c:1:3:0666" or "/run/app.fifo:p".
+func parseSpecialNodes(specs []string) ([]*specialNode, error) {
+	nodes := make([]*specialNode, 0, len(specs))
+	for _, spec := range specs {
+		node, err := parseSpecialNode(spec)
+		if err != nil {
+			return nil, fmt.Errorf("Malformed special node %q: %v", spec, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func parseSpecialNode(spec string) (*specialNode, error) {
+	fields := filepath.SplitList(spec)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("expected at least a path and a type separated with %v", listSeparator())
+	}
+	node := &specialNode{
+		path: filepath.Clean(fields[0]),
+	}
+	if !filepath.IsAbs(node.path) || node.path == "/" {
+		return nil, fmt.Errorf("path has to be absolute and it can't be the root directory")
+	}
+	rest := fields[2:]
+	switch fields[1] {
+	case "c", "b":
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("expected major and minor device numbers")
+		}
+		var err error
+		if node.major, err = strconv.ParseInt(rest[0], 10, 64); err != nil || node.major < 0 {
+			return nil, fmt.Errorf("invalid major device number %q", rest[0])
+		}
+		if node.minor, err = strconv.ParseInt(rest[1], 10, 64); err != nil || node.minor < 0 {
+			return nil, fmt.Errorf("invalid minor device number %q", rest[1])
+		}
+		rest = rest[2:]
+		if fields[1] == "c" {
+			node.typeflag = tar.TypeChar
+			node.mode = 0666
+		} else {
+			node.typeflag = tar.TypeBlock
+			node.mode = 0660
+		}
+	case "p":
+		node.typeflag = tar.TypeFifo
+		node.mode = 0644
+	case "d":
+		node.typeflag = tar.TypeDir
+		node.mode = 0755
+	default:
+		return nil, fmt.Errorf("unknown type %q, expected one of c, b, p or d", fields[1])
+	}
+	switch len(rest) {
+	case 0:
+	case 1:
+		mode, err := strconv.ParseInt(rest[0], 8, 64)
+		if err != nil || mode < 0 || mode > 07777 {
+			return nil, fmt.Errorf("invalid mode %q, expected an octal number not greater than 07777", rest[0])
+		}
+		node.mode = mode
+	default:
+		return nil, fmt.Errorf("too many fields")
+	}
+	return node, nil
+}
+
+// prepareSpecialNodes makes sure that the parent directories of the
+// special nodes exist in rootfs and creates the directory nodes, so
+// they are written to the ACI together with the rest of the rootfs.
+// The directories are kept accessible on disk, their modes are set
+// only in the ACI (see getDirAttrs). The other nodes must not exist
+// in rootfs.
+func prepareSpecialNodes(nodes []*specialNode, rootfs string) error {
+	for _, node := range nodes {
+		path := filepath.Join(rootfs, node.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if node.typeflag == tar.TypeDir {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := os.Lstat(path); err == nil {
+			return fmt.Errorf("Special node %q conflicts with a file in the ACI rootfs", node.path)
+		}
+	}
+	return nil
+}
+
+// getDirAttrs returns the asset attributes setting the modes of the
+// directory nodes in the ACI.
+func getDirAttrs(nodes []*specialNode) []*assetAttr {
+	attrs := []*assetAttr{}
+	for _, node := range nodes {
+		if node.typeflag != tar.TypeDir {
+			continue
+		}
+		mode := node.mode
+		attrs = append(attrs, &assetAttr{
+			pattern: node.path,
+			mode:    &mode,
+		})
+	}
+	return attrs
+}
+
+// getTarHeader returns a tar header describing the node, directories
+// are skipped as they are already in the rootfs.
+func (node *specialNode) getTarHeader() *tar.Header {
+	if node.typeflag == tar.TypeDir {
+		return nil
+	}
+	return &tar.Header{
+		Name:     filepath.Join("rootfs", node.path),
+		Typeflag: node.typeflag,
+		Mode:     node.mode,
+		Devmajor: node.major,
+		Devminor: node.minor,
+		ModTime:  time.Now(),
+	}
+}
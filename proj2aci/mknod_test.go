@@ -0,0 +1,109 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSpecialNode(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected *specialNode
+		fail     bool
+	}{
+		{
+			spec:     "/dev/null:c:1:3",
+			expected: &specialNode{path: "/dev/null", typeflag: tar.TypeChar, major: 1, minor: 3, mode: 0666},
+		},
+		{
+			spec:     "/dev/sda:b:8:0:0600",
+			expected: &specialNode{path: "/dev/sda", typeflag: tar.TypeBlock, major: 8, mode: 0600},
+		},
+		{
+			spec:     "/run/app.fifo/:p",
+			expected: &specialNode{path: "/run/app.fifo", typeflag: tar.TypeFifo, mode: 0644},
+		},
+		{
+			spec:     "/var/empty:d:1777",
+			expected: &specialNode{path: "/var/empty", typeflag: tar.TypeDir, mode: 01777},
+		},
+		{spec: "/dev/null", fail: true},
+		{spec: "dev/null:c:1:3", fail: true},
+		{spec: "/:d", fail: true},
+		{spec: "/dev/null:x", fail: true},
+		{spec: "/dev/null:c:1", fail: true},
+		{spec: "/dev/null:c:-1:3", fail: true},
+		{spec: "/dev/null:c:1:x", fail: true},
+		{spec: "/dev/null:c:1:3:0999", fail: true},
+		{spec: "/dev/null:c:1:3:17777", fail: true},
+		{spec: "/run/app.fifo:p:0644:0", fail: true},
+	}
+	for _, tt := range tests {
+		node, err := parseSpecialNode(tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(node, tt.expected) {
+			t.Errorf("%q: expected %+v, got %+v", tt.spec, tt.expected, node)
+		}
+	}
+}
+
+// TestPrepareSpecialDirs checks that directory nodes without access
+// for their owner stay accessible on disk and get their modes only
+// in the ACI.
+func TestPrepareSpecialDirs(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "goaci-mknod-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+	nodes, err := parseSpecialNodes([]string{"/var/empty:d:0000", "/srv/drop:d:0300", "/dev/null:c:1:3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareSpecialNodes(nodes, rootfs); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "srv", "drop", "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := filepath.Walk(rootfs, func(path string, info os.FileInfo, err error) error {
+		return err
+	}); err != nil {
+		t.Errorf("walking rootfs: %v", err)
+	}
+	walker := getTarHeaderWalker(getDirAttrs(nodes))
+	for path, mode := range map[string]int64{"/var/empty": 0, "/srv/drop": 0300, "/srv": 0755} {
+		hdr := &tar.Header{Name: filepath.Join("rootfs", path), Typeflag: tar.TypeDir, Mode: 0755}
+		walker(hdr)
+		if hdr.Mode != mode {
+			t.Errorf("%s: expected mode %o, got %o", path, mode, hdr.Mode)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// envPlaceholderPrefix starts the placeholders replaced with
+	// the values of environment variables, like "<ENV:HOME>".
+	envPlaceholderPrefix = "ENV:"
+	// placeholderEscape is replaced with a literal "<", so
+	// "<<b>" is "<b>" and not the placeholder "<b>".
+	placeholderEscape = "<<"
+)
+
+var (
+	placeholderRegexp = regexp.MustCompile(`<<|<(ENV:)?[A-Za-z_][A-Za-z0-9_]*>`)
+	defineNameRegexp  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// GetDefineString returns a properly formatted user-defined
+// placeholder string.
+func GetDefineString(name, value string) string {
+	return fmt.Sprintf("%s=%s", name, value)
+}
+
+// parseDefines parses user-defined placeholders, like "NAME=value",
+// into a mapping from placeholders (like "<NAME>") to their
+// values. The names must not clash with the builder placeholders.
+func parseDefines(specs []string, builderMapping map[string]string) (map[string]string, error) {
+	defines := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Malformed define %q, expected NAME=value", spec)
+		}
+		name, value := kv[0], kv[1]
+		if !defineNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("Invalid define name %q, expected letters, digits and underscores", name)
+		}
+		placeholder := fmt.Sprintf("<%s>", name)
+		if _, ok := builderMapping[placeholder]; ok {
+			return nil, fmt.Errorf("Define %q clashes with the builder placeholder %s", name, placeholder)
+		}
+		if _, ok := defines[placeholder]; ok {
+			return nil, fmt.Errorf("Define %q is given more than once", name)
+		}
+		defines[placeholder] = value
+	}
+	return defines, nil
+}
+
+// expandPlaceholders replaces the placeholders in s with their values
+// from mapping and the "<ENV:VAR>" placeholders with the values of
+// the environment variables. The values are not expanded again.
+// Unknown placeholders and unset environment variables are errors,
+// "<<" stands for a literal "<".
+func expandPlaceholders(s string, mapping map[string]string) (string, error) {
+	var err error
+	expanded := placeholderRegexp.ReplaceAllStringFunc(s, func(placeholder string) string {
+		if err != nil {
+			return placeholder
+		}
+		if placeholder == placeholderEscape {
+			return "<"
+		}
+		name := placeholder[1 : len(placeholder)-1]
+		if strings.HasPrefix(name, envPlaceholderPrefix) {
+			variable := strings.TrimPrefix(name, envPlaceholderPrefix)
+			value, ok := os.LookupEnv(variable)
+			if !ok {
+				err = fmt.Errorf("Environment variable %q used in %q is not set", variable, s)
+			}
+			return value
+		}
+		value, ok := mapping[placeholder]
+		if !ok {
+			err = fmt.Errorf("Unknown placeholder %s in %q, available placeholders: %s", placeholder, s, getPlaceholderList(mapping))
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+// expandAllPlaceholders expands placeholders in all the strings.
+func expandAllPlaceholders(list []string, mapping map[string]string) ([]string, error) {
+	expanded := make([]string, 0, len(list))
+	for _, s := range list {
+		e, err := expandPlaceholders(s, mapping)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, e)
+	}
+	return expanded, nil
+}
+
+// expandAssetPlaceholders expands the placeholders in both paths of
+// the asset separately, so their values can contain the path list
+// separator. Malformed assets are returned unchanged.
+func expandAssetPlaceholders(asset string, mapping map[string]string) (string, error) {
+	parts := strings.SplitN(asset, listSeparator(), 2)
+	if len(parts) != 2 {
+		return asset, nil
+	}
+	aci, err := expandPlaceholders(parts[0], mapping)
+	if err != nil {
+		return "", err
+	}
+	local, err := expandPlaceholders(parts[1], mapping)
+	if err != nil {
+		return "", err
+	}
+	return getAssetString(aci, local), nil
+}
+
+// expandAllAssetPlaceholders expands placeholders in all the assets.
+func expandAllAssetPlaceholders(assets []string, mapping map[string]string) ([]string, error) {
+	expanded := make([]string, 0, len(assets))
+	for _, asset := range assets {
+		e, err := expandAssetPlaceholders(asset, mapping)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, e)
+	}
+	return expanded, nil
+}
+
+// getPlaceholderList returns a sorted, comma separated list of the
+// placeholders in mapping and the environment placeholder.
+func getPlaceholderList(mapping map[string]string) string {
+	placeholders := make([]string, 0, len(mapping)+1)
+	for p := range mapping {
+		placeholders = append(placeholders, p)
+	}
+	sort.Strings(placeholders)
+	placeholders = append(placeholders, fmt.Sprintf("<%sVAR>", envPlaceholderPrefix))
+	return strings.Join(placeholders, ", ")
+}
+
+// mergePlaceholders returns a mapping with the placeholders of all
+// the given mappings.
+func mergePlaceholders(mappings ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, mapping := range mappings {
+		for placeholder, value := range mapping {
+			merged[placeholder] = value
+		}
+	}
+	return merged
+}
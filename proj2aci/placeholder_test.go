@@ -0,0 +1,153 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDefines(t *testing.T) {
+	builderMapping := map[string]string{"<PROJPATH>": "/src"}
+	tests := []struct {
+		specs   []string
+		defines map[string]string
+		fail    bool
+	}{
+		{specs: nil, defines: map[string]string{}},
+		{specs: []string{"A=1", "B_2=x=y", "EMPTY="}, defines: map[string]string{"<A>": "1", "<B_2>": "x=y", "<EMPTY>": ""}},
+		{specs: []string{"A"}, fail: true},
+		{specs: []string{"1A=x"}, fail: true},
+		{specs: []string{"A-B=x"}, fail: true},
+		{specs: []string{"A=1", "A=2"}, fail: true},
+		{specs: []string{"PROJPATH=/x"}, fail: true},
+	}
+	for _, tt := range tests {
+		defines, err := parseDefines(tt.specs, builderMapping)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%v: expected an error", tt.specs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.specs, err)
+			continue
+		}
+		if !reflect.DeepEqual(defines, tt.defines) {
+			t.Errorf("%v: expected %v, got %v", tt.specs, tt.defines, defines)
+		}
+	}
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	os.Setenv("GOACI_TEST_VAR", "env value")
+	os.Unsetenv("GOACI_TEST_UNSET")
+	mapping := map[string]string{
+		"<DIR>":  "/tmp/build",
+		"<LOOP>": "<DIR>",
+	}
+	tests := []struct {
+		in   string
+		out  string
+		fail bool
+	}{
+		{in: "plain", out: "plain"},
+		{in: "<DIR>/bin/<DIR>", out: "/tmp/build/bin//tmp/build"},
+		{in: "<ENV:GOACI_TEST_VAR>", out: "env value"},
+		// Values are not expanded again.
+		{in: "<LOOP>", out: "<DIR>"},
+		{in: "--html=<<b>", out: "--html=<b>"},
+		{in: "<<<DIR>", out: "</tmp/build"},
+		{in: "a < b > c", out: "a < b > c"},
+		{in: "<not a placeholder>", out: "<not a placeholder>"},
+		{in: "<UNKNOWN>", fail: true},
+		{in: "<ENV:GOACI_TEST_UNSET>", fail: true},
+	}
+	for _, tt := range tests {
+		out, err := expandPlaceholders(tt.in, mapping)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if out != tt.out {
+			t.Errorf("%q: expected %q, got %q", tt.in, tt.out, out)
+		}
+	}
+}
+
+func TestExpandAssetPlaceholders(t *testing.T) {
+	mapping := map[string]string{"<LIST>": "/a:/b"}
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{in: "/x:<LIST>", out: "/x:/a:/b"},
+		{in: "/<<x>:/y", out: "/<x>:/y"},
+		{in: "malformed", out: "malformed"},
+	}
+	for _, tt := range tests {
+		out, err := expandAssetPlaceholders(tt.in, mapping)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if out != tt.out {
+			t.Errorf("%q: expected %q, got %q", tt.in, tt.out, out)
+		}
+	}
+}
+
+// TestPrepareAssetsLiteralNames checks that the names of the files
+// found while copying the assets are not taken for placeholders.
+func TestPrepareAssetsLiteralNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goaci-placeholder-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	rootfs := filepath.Join(dir, "rootfs")
+	for _, d := range []string{src, rootfs} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "page<b>.html"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapping := map[string]string{"<SRC>": src}
+	if err := PrepareAssets([]string{"/www/:<SRC>/"}, rootfs, mapping, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, "www", "page<b>.html")); err != nil {
+		t.Error(err)
+	}
+	if err := PrepareAssets([]string{"/glob:<SRC>/*.html"}, rootfs, mapping, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, "glob", "page<b>.html")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// remoteAsset is an asset with an http or https URL as the local
+// side. The downloaded file must have the given SHA-256 checksum.
+type remoteAsset struct {
+	aci    string
+	url    string
+	sha256 string
+	mode   os.FileMode
+}
+
+// isRemoteAsset checks if the local side of the asset is an URL.
+func isRemoteAsset(asset string) bool {
+	parts := strings.SplitN(asset, listSeparator(), 2)
+	if len(parts) != 2 {
+		return false
+	}
+	return strings.HasPrefix(parts[1], "http://") || strings.HasPrefix(parts[1], "https://")
+}
+
+// parseRemoteAsset parses a remote asset. The URL has to be followed
+// by a fragment with the SHA-256 checksum of the file and optionally
+// its octal mode, like
+// "/usr/share/GeoIP/db.mmdb:https://example.com/db.mmdb#sha256=<hex>&mode=0644".
+// The default mode is 0644.
+func parseRemoteAsset(asset string) (*remoteAsset, error) {
+	parts := strings.SplitN(asset, listSeparator(), 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Malformed remote asset %q", asset)
+	}
+	u, err := url.Parse(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed URL in remote asset %q: %v", asset, err)
+	}
+	params, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed parameters of remote asset %q: %v", asset, err)
+	}
+	u.Fragment = ""
+	remote := &remoteAsset{
+		aci:    parts[0],
+		url:    u.String(),
+		sha256: strings.ToLower(params.Get("sha256")),
+		mode:   0644,
+	}
+	if decoded, err := hex.DecodeString(remote.sha256); err != nil || len(decoded) != sha256.Size {
+		return nil, fmt.Errorf("Remote asset %q needs a SHA-256 checksum, add #sha256=<hex checksum> to the URL", asset)
+	}
+	if mode := params.Get("mode"); mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 0777 {
+			return nil, fmt.Errorf("Invalid mode %q of remote asset %q", mode, asset)
+		}
+		remote.mode = os.FileMode(m)
+	}
+	return remote, nil
+}
+
+// validateRemoteAssets checks if the remote assets among assets are
+// valid.
+func validateRemoteAssets(assets []string) error {
+	for _, asset := range assets {
+		if !isRemoteAsset(asset) {
+			continue
+		}
+		if _, err := parseRemoteAsset(asset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteFetcher downloads remote assets into downloadDir. If cacheDir
+// is not empty, the downloaded files are kept there, named after
+// their checksums, so they are downloaded only once.
+type remoteFetcher struct {
+	client      *http.Client
+	cacheDir    string
+	downloadDir string
+}
+
+// getAssets returns assets with the remote assets replaced by the
+// assets of the downloaded files.
+func (f *remoteFetcher) getAssets(ctx context.Context, assets []string) ([]string, error) {
+	local := make([]string, 0, len(assets))
+	for _, asset := range assets {
+		if !isRemoteAsset(asset) {
+			local = append(local, asset)
+			continue
+		}
+		remote, err := parseRemoteAsset(asset)
+		if err != nil {
+			return nil, err
+		}
+		localPath, err := f.fetch(ctx, remote)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to fetch remote asset %q: %v", remote.url, err)
+		}
+		local = append(local, getAssetString(remote.aci, localPath))
+	}
+	return local, nil
+}
+
+// fetch downloads the remote asset, unless it is in the cache, and
+// returns the path of the downloaded file. The file is named after
+// the last element of the URL path.
+func (f *remoteFetcher) fetch(ctx context.Context, remote *remoteAsset) (string, error) {
+	u, err := url.Parse(remote.url)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		name = remote.sha256
+	}
+	dir := filepath.Join(f.downloadDir, remote.sha256)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, name)
+	if f.cacheDir != "" {
+		cached := filepath.Join(f.cacheDir, remote.sha256)
+		if sum, err := getFileHash(cached); err == nil && sum == remote.sha256 {
+			Debug("using cached ", remote.url)
+			if err := copyFile(cached, dest, remote.mode); err != nil {
+				return "", err
+			}
+			return dest, nil
+		}
+	}
+	Info(fmt.Sprintf("Downloading %s", remote.url))
+	if err := f.download(ctx, remote, dest); err != nil {
+		return "", err
+	}
+	if f.cacheDir != "" {
+		if err := os.MkdirAll(f.cacheDir, 0755); err != nil {
+			return "", err
+		}
+		if err := copyFile(dest, filepath.Join(f.cacheDir, remote.sha256), 0644); err != nil {
+			Warn(fmt.Sprintf("Failed to cache %s: %v", remote.url, err))
+		}
+	}
+	return dest, nil
+}
+
+// download writes the remote file to dest, if it has the expected
+// checksum.
+func (f *remoteFetcher) download(ctx context.Context, remote *remoteAsset, dest string) error {
+	req, err := http.NewRequest("GET", remote.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected HTTP status %q", resp.Status)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != remote.sha256 {
+		return fmt.Errorf("Checksum mismatch, expected %s, got %s", remote.sha256, sum)
+	}
+	if err := os.Chmod(tmp.Name(), remote.mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// copyFile copies src to dest, which gets the given mode.
+func copyFile(src, dest string, mode os.FileMode) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destFile, srcFile); err != nil {
+		destFile.Close()
+		return err
+	}
+	if err := destFile.Chmod(mode); err != nil {
+		destFile.Close()
+		return err
+	}
+	return destFile.Close()
+}
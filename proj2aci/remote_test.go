@@ -0,0 +1,204 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+const remoteTestContents = "remote asset contents\n"
+
+func getRemoteTestSum() string {
+	sum := sha256.Sum256([]byte(remoteTestContents))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseRemoteAsset(t *testing.T) {
+	sum := getRemoteTestSum()
+	tests := []struct {
+		asset string
+		url   string
+		mode  os.FileMode
+		fail  bool
+	}{
+		{asset: "/a:https://example.com/a#sha256=" + sum, url: "https://example.com/a", mode: 0644},
+		{asset: "/a:http://example.com/a?x=1#sha256=" + sum + "&mode=0755", url: "http://example.com/a?x=1", mode: 0755},
+		{asset: "/a:https://example.com/a", fail: true},
+		{asset: "/a:https://example.com/a#sha256=abcd", fail: true},
+		{asset: "/a:https://example.com/a#sha256=" + sum + "&mode=0999", fail: true},
+		{asset: "/a:https://example.com/a#sha256=" + sum + "&mode=01777", fail: true},
+		{asset: "https://example.com/a", fail: true},
+	}
+	for _, tt := range tests {
+		remote, err := parseRemoteAsset(tt.asset)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.asset)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.asset, err)
+			continue
+		}
+		if remote.aci != "/a" || remote.url != tt.url || remote.sha256 != sum || remote.mode != tt.mode {
+			t.Errorf("%q: got %+v", tt.asset, remote)
+		}
+	}
+}
+
+// remoteTestServer serves the test contents at /file, other contents
+// at /bad and 404 elsewhere, counting the requests.
+type remoteTestServer struct {
+	*httptest.Server
+	requests int32
+}
+
+func newRemoteTestServer() *remoteTestServer {
+	s := &remoteTestServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.requests, 1)
+		switch r.URL.Path {
+		case "/file":
+			w.Write([]byte(remoteTestContents))
+		case "/bad":
+			w.Write([]byte("tampered"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return s
+}
+
+func newRemoteTestFetcher(t *testing.T, server *remoteTestServer) (*remoteFetcher, func()) {
+	dir, err := ioutil.TempDir("", "goaci-remote-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &remoteFetcher{
+		client:      server.Client(),
+		cacheDir:    filepath.Join(dir, "cache"),
+		downloadDir: filepath.Join(dir, "download"),
+	}
+	return f, func() { os.RemoveAll(dir) }
+}
+
+// listFiles returns the paths of the regular files in dir, relative
+// to dir.
+func listFiles(t *testing.T, dir string) []string {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRemoteFetchChecksumMismatch(t *testing.T) {
+	server := newRemoteTestServer()
+	defer server.Close()
+	f, cleanup := newRemoteTestFetcher(t, server)
+	defer cleanup()
+
+	remote := &remoteAsset{aci: "/a", url: server.URL + "/bad", sha256: getRemoteTestSum(), mode: 0644}
+	if _, err := f.fetch(context.Background(), remote); err == nil {
+		t.Fatal("expected a checksum mismatch error")
+	}
+	if files := listFiles(t, f.cacheDir); len(files) != 0 {
+		t.Errorf("expected an empty cache, got %v", files)
+	}
+	if files := listFiles(t, f.downloadDir); len(files) != 0 {
+		t.Errorf("expected no downloaded files, got %v", files)
+	}
+}
+
+func TestRemoteFetchHTTPError(t *testing.T) {
+	server := newRemoteTestServer()
+	defer server.Close()
+	f, cleanup := newRemoteTestFetcher(t, server)
+	defer cleanup()
+
+	remote := &remoteAsset{aci: "/a", url: server.URL + "/missing", sha256: getRemoteTestSum(), mode: 0644}
+	if _, err := f.fetch(context.Background(), remote); err == nil {
+		t.Fatal("expected an error for 404")
+	}
+	if files := listFiles(t, f.cacheDir); len(files) != 0 {
+		t.Errorf("expected an empty cache, got %v", files)
+	}
+}
+
+func TestRemoteFetchCacheAndMode(t *testing.T) {
+	server := newRemoteTestServer()
+	defer server.Close()
+	f, cleanup := newRemoteTestFetcher(t, server)
+	defer cleanup()
+
+	asset := "/bin/tool:" + server.URL + "/file#sha256=" + getRemoteTestSum() + "&mode=0755"
+	for i := 0; i < 2; i++ {
+		// Use a fresh download directory, like the next build
+		// with a new temporary directory.
+		f.downloadDir = filepath.Join(filepath.Dir(f.cacheDir), "download"+strconv.Itoa(i))
+		assets, err := f.getAssets(context.Background(), []string{"/etc/x:/etc/x", asset})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(assets) != 2 || assets[0] != "/etc/x:/etc/x" {
+			t.Fatalf("unexpected assets %v", assets)
+		}
+		local := filepath.SplitList(assets[1])[1]
+		contents, err := ioutil.ReadFile(local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(contents) != remoteTestContents {
+			t.Errorf("fetch %d: unexpected contents %q", i, contents)
+		}
+		fi, err := os.Stat(local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0755 {
+			t.Errorf("fetch %d: expected mode 0755, got %v", i, fi.Mode().Perm())
+		}
+		if filepath.Base(local) != "file" {
+			t.Errorf("fetch %d: expected the file to be named after the URL, got %q", i, local)
+		}
+	}
+	if requests := atomic.LoadInt32(&server.requests); requests != 1 {
+		t.Errorf("expected one request, the second fetch should use the cache, got %d", requests)
+	}
+}
@@ -15,6 +15,7 @@
 package proj2aci
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -45,7 +46,10 @@ func (e CmdNotFoundError) Error() string {
 // If execution fails then CmdFailedError is returned. This can be
 // useful if we don't care if execution fails or not. CmdNotFoundError
 // is returned if executable is not found.
-func RunCmdFull(execProg string, args, env []string, cwd string, stdout, stderr io.Writer) error {
+//
+// When ctx is done, the command is killed together with its children
+// and CmdFailedError wrapping the context error is returned.
+func RunCmdFull(ctx context.Context, execProg string, args, env []string, cwd string, stdout, stderr io.Writer) error {
 	if len(args) < 1 {
 		return fmt.Errorf("No args to execute passed")
 	}
@@ -67,13 +71,61 @@ func RunCmdFull(execProg string, args, env []string, cwd string, stdout, stderr
 		Stdout: stdout,
 		Stderr: stderr,
 	}
+	setProcessGroup(&cmd)
 	Debug(`running command: "`, strings.Join(args, `" "`), `"`)
-	if err := cmd.Run(); err != nil {
+	if err := ctx.Err(); err != nil {
+		return CmdFailedError{err}
+	}
+	if err := cmd.Start(); err != nil {
 		return CmdFailedError{err}
 	}
-	return nil
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return CmdFailedError{err}
+		}
+		return nil
+	case <-ctx.Done():
+		Debug("killing ", args[0], ": ", ctx.Err())
+		if err := killProcessGroup(&cmd); err != nil {
+			Warn(fmt.Sprintf("Failed to kill %q: %v", args[0], err))
+		}
+		<-done
+		return CmdFailedError{ctx.Err()}
+	}
 }
 
-func RunCmd(args, env []string, cwd string) error {
-	return RunCmdFull("", args, env, cwd, os.Stdout, os.Stderr)
+func RunCmd(ctx context.Context, args, env []string, cwd string) error {
+	stdout, stderr := getCmdOutput(ctx)
+	return RunCmdFull(ctx, "", args, env, cwd, stdout, stderr)
+}
+
+type cmdOutputKey struct{}
+
+type cmdOutput struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// WithCmdOutput returns a copy of ctx making RunCmd and the builders
+// write the output of the commands they run to stdout and stderr
+// instead of os.Stdout and os.Stderr.
+func WithCmdOutput(ctx context.Context, stdout, stderr io.Writer) context.Context {
+	return context.WithValue(ctx, cmdOutputKey{}, &cmdOutput{
+		stdout: stdout,
+		stderr: stderr,
+	})
+}
+
+// getCmdOutput returns the writers for the output of the commands run
+// with ctx.
+func getCmdOutput(ctx context.Context) (io.Writer, io.Writer) {
+	if output, ok := ctx.Value(cmdOutputKey{}).(*cmdOutput); ok {
+		return output.stdout, output.stderr
+	}
+	return os.Stdout, os.Stderr
 }
@@ -0,0 +1,220 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxShebangLength is the maximum length of the shebang line
+	// read by the kernel.
+	maxShebangLength = 256
+	// defaultPath is the PATH used for looking up interpreters
+	// run through env.
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// largeRuntimes are the interpreters which need more than just their
+// binary and shared libraries to run, like a standard library tree.
+var largeRuntimes = map[string]struct{}{
+	"python":  {},
+	"pypy":    {},
+	"perl":    {},
+	"ruby":    {},
+	"node":    {},
+	"nodejs":  {},
+	"php":     {},
+	"java":    {},
+	"Rscript": {},
+	"tclsh":   {},
+	"wish":    {},
+}
+
+// readShebang returns the interpreter and its arguments from the
+// shebang line of a file. It returns an empty interpreter if the file
+// is not a script.
+func readShebang(path string) (string, []string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return "", nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, maxShebangLength)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	buf = buf[:n]
+	if !bytes.HasPrefix(buf, []byte("#!")) {
+		return "", nil, nil
+	}
+	line := buf[2:]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return "", nil, nil
+	}
+	return fields[0], fields[1:], nil
+}
+
+// getInterpreterAssets returns the assets for the interpreter of the
+// given asset if it is a script. Scripts run through env get both env
+// and the interpreter found in the default PATH.
+func (p *assetsPreparer) getInterpreterAssets(ACIAsset, localAsset string) ([]string, error) {
+	interp, args, err := readShebang(localAsset)
+	if err != nil || interp == "" {
+		return nil, err
+	}
+	if !filepath.IsAbs(interp) {
+		Warn(fmt.Sprintf("Interpreter %q of %q is not an absolute path, not adding it", interp, ACIAsset))
+		return nil, nil
+	}
+	assets, err := p.getProgramAssets(interp)
+	if err != nil {
+		return nil, err
+	}
+	if assets == nil {
+		Warn(fmt.Sprintf("Could not find interpreter %q of %q", interp, ACIAsset))
+		return nil, nil
+	}
+	Debug("script ", ACIAsset, " needs interpreter ", interp)
+	if filepath.Base(interp) == "env" {
+		prog := getEnvProgram(args)
+		if prog == "" {
+			Warn(fmt.Sprintf("Could not find the program run through %q by %q", interp, ACIAsset))
+			return assets, nil
+		}
+		progAssets, err := p.getProgramAssets(prog)
+		if err != nil {
+			return nil, err
+		}
+		if progAssets == nil {
+			Warn(fmt.Sprintf("Could not find interpreter %q of %q (looked in %s)", prog, ACIAsset, defaultPath))
+			return assets, nil
+		}
+		Debug("script ", ACIAsset, " needs interpreter ", prog)
+		interp = prog
+		assets = append(assets, progAssets...)
+	}
+	if _, ok := largeRuntimes[getRuntimeName(interp)]; ok {
+		Warn(fmt.Sprintf("Interpreter %q of %q likely needs its runtime files (like a standard library), which are not added automatically - add them as assets", interp, ACIAsset))
+	}
+	return assets, nil
+}
+
+// getProgramAssets finds a program in the sysroot (or install roots)
+// and returns the assets for it and the symlinks leading to it. The
+// program is either an absolute path or a name looked up in the
+// default PATH. It returns nil if the program was not found.
+func (p *assetsPreparer) getProgramAssets(prog string) ([]string, error) {
+	dirs := filepath.SplitList(defaultPath)
+	if filepath.IsAbs(prog) {
+		dirs = []string{filepath.Dir(prog)}
+	} else if strings.Contains(prog, "/") {
+		return nil, nil
+	}
+	for _, dir := range dirs {
+		for _, root := range p.resolver.rootDirs(dir) {
+			localPath := filepath.Join(root.local, filepath.Base(prog))
+			if fi, err := os.Stat(localPath); err != nil || fi.IsDir() {
+				continue
+			}
+			return p.resolver.getSymlinkedAssets(filepath.Join(root.aci, filepath.Base(prog)), localPath)
+		}
+	}
+	return nil, nil
+}
+
+// envValueOptions are the env options taking a value, which is either
+// the next argument or attached to the option (like -uNAME or
+// --unset=NAME).
+var envValueOptions = map[string]struct{}{
+	"-u":             {},
+	"--unset":        {},
+	"-C":             {},
+	"--chdir":        {},
+	"-P":             {},
+	"-S":             {},
+	"--split-string": {},
+}
+
+// getEnvProgram returns the program env is asked to run, skipping
+// options with their values and environment variable assignments.
+// The words of the -S string are taken as arguments, the shebang line
+// is split into words already.
+func getEnvProgram(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return getEnvProgramAfterOptions(args[i+1:])
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return getEnvProgramAfterOptions(args[i:])
+		}
+		name, value := arg, ""
+		if strings.HasPrefix(arg, "--") {
+			if eq := strings.Index(arg, "="); eq >= 0 {
+				name, value = arg[:eq], arg[eq+1:]
+			}
+		} else if len(arg) > 2 {
+			name, value = arg[:2], arg[2:]
+		}
+		if _, ok := envValueOptions[name]; !ok {
+			continue
+		}
+		if name == "-S" || name == "--split-string" {
+			if value != "" {
+				return getEnvProgram(append(strings.Fields(value), args[i+1:]...))
+			}
+			continue
+		}
+		if value == "" && name == arg {
+			i++
+		}
+	}
+	return ""
+}
+
+// getEnvProgramAfterOptions returns the first argument that is not an
+// environment variable assignment.
+func getEnvProgramAfterOptions(args []string) string {
+	for _, arg := range args {
+		if !strings.Contains(arg, "=") {
+			return arg
+		}
+	}
+	return ""
+}
+
+// getRuntimeName strips the version from the interpreter name, so
+// "python3.11" becomes "python".
+func getRuntimeName(interp string) string {
+	return strings.TrimRight(filepath.Base(interp), "0123456789.-")
+}
@@ -0,0 +1,51 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetEnvProgram(t *testing.T) {
+	tests := []struct {
+		args string
+		prog string
+	}{
+		{args: "python3", prog: "python3"},
+		{args: "-i PATH=/bin python3 -u", prog: "python3"},
+		{args: "- python3", prog: "python3"},
+		{args: "-u PYTHONPATH python3", prog: "python3"},
+		{args: "-uPYTHONPATH python3", prog: "python3"},
+		{args: "--unset PYTHONPATH python3", prog: "python3"},
+		{args: "--unset=PYTHONPATH python3", prog: "python3"},
+		{args: "-C /tmp ruby", prog: "ruby"},
+		{args: "--chdir=/tmp ruby", prog: "ruby"},
+		{args: "-S python3 -u", prog: "python3"},
+		{args: "-S -u HOME A=1 node --harmony", prog: "node"},
+		{args: "-Spython3 -u", prog: "python3"},
+		{args: "--split-string=python3 -u", prog: "python3"},
+		{args: "-v -- A=1 perl -w", prog: "perl"},
+		{args: "-- -weird", prog: "-weird"},
+		{args: "-u", prog: ""},
+		{args: "A=1 B=2", prog: ""},
+		{args: "", prog: ""},
+	}
+	for _, tt := range tests {
+		if prog := getEnvProgram(strings.Fields(tt.args)); prog != tt.prog {
+			t.Errorf("%q: expected %q, got %q", tt.args, tt.prog, prog)
+		}
+	}
+}
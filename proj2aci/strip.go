@@ -0,0 +1,279 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// debugInfoDir is a directory where debuggers look for
+	// separate debug info files.
+	debugInfoDir = "/usr/lib/debug"
+	// ntGNUBuildID is a type of the ELF note holding the GNU
+	// build-id.
+	ntGNUBuildID = 3
+)
+
+// stripRootfs removes symbols and debug info from ELF executables
+// and shared libraries in rootfs using objcopy. If debugRootfs is not
+// empty, the debug info is saved in separate files inside it, at
+// paths where debuggers look for them (see getDebugFilePath).
+func stripRootfs(ctx context.Context, objcopy, rootfs, debugRootfs string) error {
+	seen := make(map[fileID]struct{})
+	return filepath.Walk(rootfs, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		// hardlinks are stripped only once, the contents are
+		// replaced in place
+		if id, ok := getFileID(info); ok {
+			if _, stripped := seen[id]; stripped {
+				return nil
+			}
+			seen[id] = struct{}{}
+		}
+		aciPath, _ := getSubPath(rootfs, path)
+		if err := stripElfFile(ctx, objcopy, path, aciPath, debugRootfs); err != nil {
+			return fmt.Errorf("Failed to strip %q: %v", aciPath, err)
+		}
+		return nil
+	})
+}
+
+// stripElfFile strips a single file if it is an ELF executable or a
+// shared library with symbols or debug info.
+func stripElfFile(ctx context.Context, objcopy, path, aciPath, debugRootfs string) error {
+	isElf, err := hasElfMagic(path)
+	if err != nil || !isElf {
+		return err
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if (f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN) || !hasSymbols(f) {
+		return nil
+	}
+	buildID, err := getBuildID(f)
+	if err != nil {
+		return err
+	}
+	stripMode := "--strip-all"
+	if isSharedLibrary(f) {
+		stripMode = "--strip-unneeded"
+	}
+
+	tmpDir, err := ioutil.TempDir("", "goaci-strip-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	stripped := filepath.Join(tmpDir, "stripped")
+	stripArgs := []string{objcopy, stripMode}
+	if debugRootfs != "" {
+		debugPath := filepath.Join(debugRootfs, getDebugFilePath(buildID, aciPath))
+		if buildID == "" {
+			Warn(fmt.Sprintf("%q has no GNU build-id, its debug info is stored by path as %q", aciPath, getDebugFilePath(buildID, aciPath)))
+		}
+		if err := os.MkdirAll(filepath.Dir(debugPath), 0755); err != nil {
+			return err
+		}
+		if err := RunCmd(ctx, []string{objcopy, "--only-keep-debug", path, debugPath}, nil, ""); err != nil {
+			return err
+		}
+		if err := os.Chmod(debugPath, 0644); err != nil {
+			return err
+		}
+		stripArgs = append(stripArgs, "--add-gnu-debuglink="+debugPath)
+	}
+	Debug("stripping ", aciPath)
+	if err := RunCmd(ctx, append(stripArgs, path, stripped), nil, ""); err != nil {
+		return err
+	}
+	return replaceContents(path, stripped)
+}
+
+// hasSymbols checks if there is anything to strip in the ELF file.
+func hasSymbols(f *elf.File) bool {
+	for _, section := range f.Sections {
+		if section.Type == elf.SHT_SYMTAB || strings.HasPrefix(section.Name, ".debug_") || strings.HasPrefix(section.Name, ".zdebug_") {
+			return true
+		}
+	}
+	return false
+}
+
+// isSharedLibrary checks if the ELF file is a shared library. Those
+// are stripped less aggressively, so they still can be linked
+// against. Position independent executables are ET_DYN too, but they
+// have an interpreter.
+func isSharedLibrary(f *elf.File) bool {
+	if f.Type != elf.ET_DYN {
+		return false
+	}
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			return false
+		}
+	}
+	return true
+}
+
+// getBuildID returns the GNU build-id of the ELF file as a hex
+// string. It returns an empty string if the file has no build-id.
+func getBuildID(f *elf.File) (string, error) {
+	for _, section := range f.Sections {
+		if section.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			return "", err
+		}
+		// each note is a header of name size, description size
+		// and type, followed by the name and the description,
+		// both padded to 4 bytes
+		for len(data) >= 12 {
+			nameSize := int(f.ByteOrder.Uint32(data[0:]))
+			descSize := int(f.ByteOrder.Uint32(data[4:]))
+			noteType := f.ByteOrder.Uint32(data[8:])
+			nameEnd := 12 + align4(nameSize)
+			descEnd := nameEnd + align4(descSize)
+			if nameSize < 0 || descSize < 0 || descEnd > len(data) {
+				break
+			}
+			name := strings.TrimRight(string(data[12:12+nameSize]), "\x00")
+			if noteType == ntGNUBuildID && name == "GNU" && descSize > 0 {
+				return hex.EncodeToString(data[nameEnd : nameEnd+descSize]), nil
+			}
+			data = data[descEnd:]
+		}
+	}
+	return "", nil
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// getDebugFilePath returns a path of the debug info file relative to
+// the debug rootfs. Files with a build-id get the usual
+// /usr/lib/debug/.build-id/xx/yyyy.debug path, the other ones are
+// stored under /usr/lib/debug at the path of the stripped file.
+func getDebugFilePath(buildID, aciPath string) string {
+	if len(buildID) > 2 {
+		return filepath.Join(debugInfoDir, ".build-id", buildID[:2], buildID[2:]+".debug")
+	}
+	return filepath.Join(debugInfoDir, aciPath+".debug")
+}
+
+// replaceContents overwrites the contents of dest with the contents
+// of src, so the inode of dest, its permissions and hardlinks to it
+// are preserved.
+func replaceContents(dest, src string) error {
+	fi, err := os.Stat(dest)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0200 == 0 {
+		if err := os.Chmod(dest, fi.Mode().Perm()|0200); err != nil {
+			return err
+		}
+		defer os.Chmod(dest, fi.Mode().Perm())
+	}
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	destFile, err := os.OpenFile(dest, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destFile, srcFile); err != nil {
+		destFile.Close()
+		return err
+	}
+	return destFile.Close()
+}
+
+// writeDebugArchive writes the contents of debugRootfs to a gzipped
+// tar archive, so it can be unpacked in the root directory of a
+// system used for debugging.
+func writeDebugArchive(debugRootfs, filename string) error {
+	of, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening debug archive file: %v", err)
+	}
+	defer of.Close()
+	gw := gzip.NewWriter(of)
+	tw := tar.NewWriter(gw)
+	err = filepath.Walk(debugRootfs, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(debugRootfs, path)
+		if err != nil || name == "." {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid = 0
+		hdr.Gid = 0
+		hdr.Uname = ""
+		hdr.Gname = ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
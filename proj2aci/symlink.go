@@ -0,0 +1,182 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// SymlinkStyleRelative makes rewritten symlinks relative to
+	// their directory.
+	SymlinkStyleRelative = "relative"
+	// SymlinkStyleAbsolute makes rewritten symlinks absolute
+	// paths in the ACI rootfs.
+	SymlinkStyleAbsolute = "absolute"
+)
+
+// runtimeDirs are the directories mounted by the container runtime,
+// symlinks pointing there are not dangling.
+var runtimeDirs = []string{"/proc", "/sys", "/dev"}
+
+// ValidateSymlinkStyle checks if style is a known symlink style. An
+// empty style means relative.
+func ValidateSymlinkStyle(style string) error {
+	switch style {
+	case "", SymlinkStyleRelative, SymlinkStyleAbsolute:
+		return nil
+	}
+	return fmt.Errorf("Unknown symlink style %q, expected %s or %s", style, SymlinkStyleRelative, SymlinkStyleAbsolute)
+}
+
+// fixSymlinks rewrites the copied symlinks with absolute targets
+// pointing into the copied local directories (or the install roots),
+// so they point to the matching paths in the ACI rootfs. Symlinks
+// pointing into the build directories (the placeholder values) which
+// were not copied are reported as errors, they would be always
+// dangling.
+func (p *assetsPreparer) fixSymlinks() error {
+	for _, link := range p.symlinks {
+		path := filepath.Join(p.rootfs, link)
+		// the symlink could be overwritten by some other asset
+		if fi, err := os.Lstat(path); err != nil || !isSymlink(fi.Mode()) {
+			continue
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) {
+			continue
+		}
+		aciTarget, ok := p.getACIPath(target)
+		if !ok {
+			if dir := p.getBuildDir(target); dir != "" {
+				return fmt.Errorf("Symlink %q points to %q inside the build directory %q, which is not copied to the ACI", link, target, dir)
+			}
+			continue
+		}
+		if aciTarget == target {
+			// already points to the right place
+			continue
+		}
+		newTarget := aciTarget
+		if p.symlinkStyle != SymlinkStyleAbsolute {
+			if newTarget, err = filepath.Rel(filepath.Dir(link), aciTarget); err != nil {
+				return err
+			}
+		}
+		Debug("rewriting symlink ", link, " from ", target, " to ", newTarget)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if err := os.Symlink(newTarget, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getACIPath maps a local path to a path in the ACI rootfs, using the
+// install roots and the copied local directories. The copied
+// directory with the longest matching path wins.
+func (p *assetsPreparer) getACIPath(localPath string) (string, bool) {
+	candidates := []string{filepath.Clean(localPath)}
+	if evaluated, err := evalPath(localPath); err == nil && evaluated != candidates[0] {
+		candidates = append(candidates, evaluated)
+	}
+	for _, candidate := range candidates {
+		if dir := p.resolver.installRootDir(candidate); dir != nil {
+			return dir.aci, true
+		}
+		best := -1
+		for i, mapping := range p.mappings {
+			if _, ok := getSubPath(mapping.local, candidate); !ok {
+				continue
+			}
+			if best < 0 || len(mapping.local) > len(p.mappings[best].local) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			rel, _ := filepath.Rel(p.mappings[best].local, candidate)
+			return filepath.Join(p.mappings[best].aci, rel), true
+		}
+	}
+	return "", false
+}
+
+// getBuildDir returns the build directory (one of the placeholder
+// values) containing path, it returns an empty string if path is not
+// in any of them.
+func (p *assetsPreparer) getBuildDir(path string) string {
+	for _, dir := range p.placeholderMapping {
+		if dir == "" {
+			continue
+		}
+		if _, ok := getSubPath(dir, path); ok {
+			return dir
+		}
+	}
+	return ""
+}
+
+// findDanglingSymlinks returns the paths of symlinks in rootfs which
+// point to nonexistent files. Symlinks pointing to the directories
+// mounted by the container runtime or to the extra paths (like the
+// special nodes, which are not in rootfs) are fine.
+func findDanglingSymlinks(rootfs string, extraPaths []string) ([]string, error) {
+	extra := make(map[string]struct{}, len(extraPaths))
+	for _, path := range extraPaths {
+		extra[filepath.Clean(path)] = struct{}{}
+	}
+	dangling := []string{}
+	err := filepath.Walk(rootfs, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !isSymlink(info.Mode()) {
+			return nil
+		}
+		// symlink loops are dangling too
+		if _, err := evalSymlinksInRoot(rootfs, path); err == nil {
+			return nil
+		}
+		aciPath, _ := getSubPath(rootfs, path)
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(aciPath), target)
+		}
+		if _, ok := extra[filepath.Clean(target)]; ok {
+			return nil
+		}
+		for _, dir := range runtimeDirs {
+			if _, ok := getSubPath(dir, target); ok {
+				return nil
+			}
+		}
+		dangling = append(dangling, fmt.Sprintf("%s -> %s", aciPath, target))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dangling, nil
+}
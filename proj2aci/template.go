@@ -0,0 +1,95 @@
+// Copyright 2016 The appc Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proj2aci
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is the data available in the template assets.
+type TemplateData struct {
+	// Project is the project being built.
+	Project string
+	// Builder is the name of the builder, like "go" or "cmake".
+	Builder string
+	// Revision is the VCS revision of the project, empty if the
+	// project is not in a repository.
+	Revision string
+	// Version is the value of the VERSION define, empty if it is
+	// not defined.
+	Version string
+	// Labels are the labels of the ACI.
+	Labels map[string]string
+	// Defines are the user-defined placeholders, keyed by their
+	// names without the angle brackets.
+	Defines map[string]string
+}
+
+// templateFuncs are the functions available in the template assets
+// in addition to the text/template builtins.
+var templateFuncs = template.FuncMap{
+	"env":   os.Getenv,
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// parseTemplateAssets parses template asset specifications. Each
+// specification is a path in the ACI rootfs and a local template file
+// separated with the path list separator, like assets without globs.
+func parseTemplateAssets(specs []string) ([]assetPair, error) {
+	assets := make([]assetPair, 0, len(specs))
+	for _, spec := range specs {
+		fields := filepath.SplitList(spec)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Malformed template asset %q, expected a path in ACI rootfs and a template separated with %v", spec, listSeparator())
+		}
+		if strings.HasSuffix(fields[1], "/") || hasGlobMeta(fields[1]) {
+			return nil, fmt.Errorf("Malformed template asset %q, the template has to be a single file", spec)
+		}
+		assets = append(assets, assetPair{aci: fields[0], local: fields[1]})
+	}
+	return assets, nil
+}
+
+// renderTemplate renders the template file src into dest, which gets
+// the mode of src. Missing map keys (like undefined defines) are
+// errors.
+func renderTemplate(src, dest string, data *TemplateData) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(filepath.Base(src)).Funcs(templateFuncs).Option("missingkey=error").ParseFiles(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -16,7 +16,6 @@ package proj2aci
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -41,29 +40,90 @@ func DirExists(path string) bool {
 	return true
 }
 
-func printTo(w io.Writer, i ...interface{}) {
+func logMessage(level LogLevel, i ...interface{}) {
 	s := fmt.Sprint(i...)
-	fmt.Fprintln(w, strings.TrimSuffix(s, "\n"))
+	GetLogger().Log(level, nil, strings.TrimSuffix(s, "\n"))
 }
 
 func Warn(i ...interface{}) {
-	printTo(os.Stderr, i...)
+	logMessage(LogWarn, i...)
 }
 
 func Info(i ...interface{}) {
-	printTo(os.Stdout, i...)
+	logMessage(LogInfo, i...)
 }
 
 func Debug(i ...interface{}) {
-	if debugEnabled {
-		printTo(os.Stdout, i...)
-	}
+	logMessage(LogDebug, i...)
 }
 
 func InitDebug() {
 	if os.Getenv("GOACI_DEBUG") != "" {
 		debugEnabled = true
+		SetLogger(&streamLogger{
+			minLevel: LogDebug,
+			stdout:   os.Stdout,
+			stderr:   os.Stderr,
+		})
+	}
+}
+
+// getSubPath checks if path is inside dir. If so, it returns the path
+// relative to dir, but starting with a slash, so it can be used as a
+// path in the ACI rootfs.
+func getSubPath(dir, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join(string(filepath.Separator), rel), true
+}
+
+// evalSymlinksInRoot works like filepath.EvalSymlinks, but absolute
+// symlinks and ".." elements are resolved relative to root, so it can
+// be used for files inside a sysroot or the ACI rootfs. path has to
+// be inside root.
+func evalSymlinksInRoot(root, path string) (string, error) {
+	rel, ok := getSubPath(root, path)
+	if !ok {
+		return "", fmt.Errorf("%q is not inside %q", path, root)
+	}
+	maxLinks := 255
+	resolved := string(filepath.Separator)
+	rest := splitPath(rel)
+	for len(rest) > 0 {
+		elem := rest[0]
+		rest = rest[1:]
+		switch elem {
+		case ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, elem)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if !isSymlink(fi.Mode()) {
+			resolved = next
+			continue
+		}
+		maxLinks--
+		if maxLinks < 0 {
+			return "", fmt.Errorf("Too many levels of symlinks in %q", path)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = string(filepath.Separator)
+		}
+		rest = append(splitPath(target), rest...)
 	}
+	return filepath.Join(root, resolved), nil
 }
 
 // listSeparator returns filepath.ListSeparator rune as a string.
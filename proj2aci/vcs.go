@@ -17,12 +17,23 @@ package proj2aci
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 )
 
+// NoVCSError is returned by GetVCSInfo when no known code repository
+// was found in the project directory or any of its parents.
+type NoVCSError struct {
+	Path string
+}
+
+func (e NoVCSError) Error() string {
+	return fmt.Sprintf("Unknown code repository in %q or any of its parent directories", e.Path)
+}
+
 func repoDirExists(projPath, repoDir string) bool {
 	path := filepath.Join(projPath, repoDir)
 	info, err := os.Stat(path)
@@ -32,6 +43,22 @@ func repoDirExists(projPath, repoDir string) bool {
 	return info.IsDir()
 }
 
+// gitFileExists checks if projPath contains a .git file pointing to
+// the actual git directory. Such files are created for worktrees and
+// submodules.
+func gitFileExists(projPath string) bool {
+	path := filepath.Join(projPath, ".git")
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(string(contents), "gitdir:")
+}
+
 // getId gets first line of commands output which should hold some VCS
 // specific id of current code checkout.
 func getId(dir, cmd string, params []string) (string, error) {
@@ -78,7 +105,7 @@ type VCSInfo interface {
 type GitInfo struct{}
 
 func (info GitInfo) IsValid(path string) bool {
-	return repoDirExists(path, ".git")
+	return repoDirExists(path, ".git") || gitFileExists(path)
 }
 
 func (info GitInfo) GetLabelAndId(path string) (string, string, error) {
@@ -115,6 +142,11 @@ func (info BzrInfo) GetLabelAndId(path string) (string, string, error) {
 	return getLabelAndId("bzr", path, "bzr", []string{"revno"})
 }
 
+// GetVCSInfo returns a VCS name and an id of the current checkout of
+// the repository containing projPath. The repository root is searched
+// for by walking up from projPath, so projPath can be a subdirectory
+// of the repository (like a subpackage of a go project). NoVCSError is
+// returned if no repository was found.
 func GetVCSInfo(projPath string) (string, string, error) {
 	vcses := []VCSInfo{
 		GitInfo{},
@@ -123,10 +155,22 @@ func GetVCSInfo(projPath string) (string, string, error) {
 		BzrInfo{},
 	}
 
-	for _, vcs := range vcses {
-		if vcs.IsValid(projPath) {
-			return vcs.GetLabelAndId(projPath)
+	absPath, err := filepath.Abs(projPath)
+	if err != nil {
+		return "", "", err
+	}
+	for dir := absPath; ; {
+		for _, vcs := range vcses {
+			if vcs.IsValid(dir) {
+				Debug("found repository root: ", dir)
+				return vcs.GetLabelAndId(dir)
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
 		}
+		dir = parent
 	}
-	return "", "", fmt.Errorf("Unknown code repository in %q", projPath)
+	return "", "", NoVCSError{Path: projPath}
 }